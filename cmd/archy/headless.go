@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/tallenh/archy/internal/config"
+	"github.com/tallenh/archy/internal/installer"
+)
+
+// runHeadless runs the installer without the wizard, printing phase changes
+// and log lines to w as plain text. Every value the wizard would prompt for
+// must already be set by archy.toml or the environment. An SSH key from the
+// config is only installed when approveSSHKey stands in for the wizard's
+// APPROVE.
+func runHeadless(cfg *config.InstallConfig, exec installer.Executor, approveSSHKey bool, w io.Writer) error {
+	if missing := cfg.Missing(); len(missing) > 0 {
+		return fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
+	}
+	if cfg.SSHPubKeyFromConfig && !approveSSHKey {
+		return fmt.Errorf("archy.toml sets ssh_pubkey_file: pass --approve-ssh-key to install it without the wizard")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprint(w, cfg.Summary())
+
+	ch := make(chan installer.PhaseUpdate, 20)
+	inst := installer.New(cfg, exec, ch)
+	go func() {
+		inst.Run(ctx)
+		close(ch)
+	}()
+
+	var phase string
+	var err error
+	for u := range ch {
+		if u.LogLine != "" {
+			fmt.Fprintf(w, "    %s\n", u.LogLine)
+			continue
+		}
+		if p := u.Prefetch; p != nil {
+			if p.Err != nil {
+				fmt.Fprintf(w, "    Background package download failed: %v\n", p.Err)
+			} else if p.Done {
+				fmt.Fprintf(w, "    Background package download finished\n")
+			}
+			continue
+		}
+		if u.Err != nil {
+			err = fmt.Errorf("%s: %w", u.Description, u.Err)
+			continue
+		}
+		if u.Description != phase {
+			phase = u.Description
+			fmt.Fprintf(w, "==> [%3.0f%%] %s\n", u.Percent*100, phase)
+		}
+	}
+	return err
+}
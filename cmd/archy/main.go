@@ -1,19 +1,43 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	tea "github.com/charmbracelet/bubbletea"
 
 	"github.com/tallenh/archy/internal/config"
+	"github.com/tallenh/archy/internal/installer"
 	"github.com/tallenh/archy/internal/system"
 	"github.com/tallenh/archy/internal/tui"
 	"github.com/tallenh/archy/internal/tui/steps"
 )
 
+// version is set at build time by GoReleaser.
+var version = "dev"
+
 func main() {
-	if os.Geteuid() != 0 {
+	dryRun := flag.Bool("dry-run", false, "record the install plan instead of touching disks")
+	dryRunOutput := flag.String("dry-run-output", "", "write the dry-run plan to this file instead of stdout")
+	resume := flag.Bool("resume", false, "continue a failed install from its checkpoint")
+	headless := flag.Bool("headless", false, "install from archy.toml and ARCHY_* variables without the wizard")
+	approveSSHKey := flag.Bool("approve-ssh-key", false, "with --headless, install the ssh_pubkey_file from archy.toml")
+	onlyPhases := flag.String("only-phases", "", "comma-separated phases to run, e.g. software,dotfiles (see --list-phases)")
+	listPhases := flag.Bool("list-phases", false, "list the install phases and exit")
+	flag.Parse()
+
+	installer.Version = version
+
+	if *listPhases {
+		printPhases(os.Stdout)
+		return
+	}
+
+	if !*dryRun && os.Geteuid() != 0 {
 		fmt.Fprintln(os.Stderr, "archy must be run as root")
 		os.Exit(1)
 	}
@@ -43,6 +67,15 @@ func main() {
 		EFISize:     "512M",
 		ZRAMSize:    defaultZRAM,
 		DockerGroup: true,
+		DryRun:      *dryRun,
+		Resume:      *resume,
+	}
+	if *onlyPhases != "" {
+		cfg.OnlyPhases = strings.Split(*onlyPhases, ",")
+		if err := installer.CheckPhases(cfg.OnlyPhases); err != nil {
+			fmt.Fprintf(os.Stderr, "--only-phases: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Load config file and environment variables
@@ -50,6 +83,36 @@ func main() {
 		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
 		os.Exit(1)
 	}
+	if err := installer.CheckHooks(cfg.Hooks); err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: archy.toml: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *resume {
+		if err := applyCheckpoint(cfg, disks); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot resume: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var exec installer.Executor = installer.LocalExecutor{}
+	var recorder *installer.Recorder
+	if *dryRun {
+		recorder = installer.NewRecorder()
+		exec = recorder
+	}
+
+	if *headless {
+		err := runHeadless(cfg, exec, *approveSSHKey, os.Stdout)
+		if recorder != nil && err == nil {
+			err = writePlan(recorder, *dryRunOutput)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "install failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Build step models
 	stepModels := []tui.StepModel{
@@ -70,7 +133,7 @@ func main() {
 		steps.NewSSHPubKey(cfg),                   // 14
 		steps.NewDocker(cfg),                      // 15
 		steps.NewConfirm(cfg),                     // 16
-		steps.NewInstall(cfg),                     // 17
+		steps.NewInstall(cfg, exec),               // 17
 	}
 
 	m := tui.NewModel(cfg, stepModels)
@@ -79,4 +142,63 @@ func main() {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
+
+	if recorder != nil {
+		if err := writePlan(recorder, *dryRunOutput); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write install plan: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// applyCheckpoint fixes the device, encryption and partition settings to
+// those recorded by the install being resumed.
+func applyCheckpoint(cfg *config.InstallConfig, disks []config.BlockDevice) error {
+	cp, err := installer.LoadCheckpoint(installer.CheckpointPath)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, d := range disks {
+		if d.Path() == cp.Device {
+			cfg.Device = d
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("checkpoint device %s not found", cp.Device)
+	}
+	cp.Apply(cfg)
+	cfg.Resume = true
+	return nil
+}
+
+// printPhases lists the registered phases in the order they run.
+func printPhases(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tWEIGHT\tDEPENDS ON\tDESCRIPTION")
+	for _, d := range installer.Phases() {
+		deps := make([]string, len(d.Deps))
+		for i, dep := range d.Deps {
+			deps[i] = dep.Name()
+		}
+		fmt.Fprintf(tw, "%s\t%g\t%s\t%s\n", d.Phase.Name(), d.Weight, strings.Join(deps, ","), d.Description)
+	}
+	tw.Flush()
+}
+
+// writePlan writes the recorded dry-run plan to path, or to stdout when path is empty.
+func writePlan(rec *installer.Recorder, path string) error {
+	if path == "" {
+		return rec.WritePlan(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := rec.WritePlan(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
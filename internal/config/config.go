@@ -60,11 +60,58 @@ func (d DesktopEnvironment) DisplayManager() string {
 	}
 }
 
+// Filesystem is the filesystem the root partition is formatted with.
+type Filesystem int
+
+const (
+	FilesystemBtrfs Filesystem = iota
+	FilesystemExt4
+	FilesystemXFS
+)
+
+func (f Filesystem) String() string {
+	switch f {
+	case FilesystemExt4:
+		return "ext4"
+	case FilesystemXFS:
+		return "xfs"
+	default:
+		return "btrfs"
+	}
+}
+
+// Packages returns the pacman packages with the filesystem's tools.
+func (f Filesystem) Packages() []string {
+	switch f {
+	case FilesystemExt4:
+		return []string{"e2fsprogs"}
+	case FilesystemXFS:
+		return []string{"xfsprogs"}
+	default:
+		return []string{"btrfs-progs"}
+	}
+}
+
 // BlockDevice represents a disk detected by lsblk.
 type BlockDevice struct {
-	Name string // e.g. "sda", "nvme0n1"
-	Size string // e.g. "500G"
+	Name  string // e.g. "sda", "nvme0n1"
+	Size  string // e.g. "500G"
 	Model string
+	SSD   bool            // not rotational
+	NVMe  bool            // attached over NVMe
+	Table *PartitionTable // existing partition table, nil when the disk has none
+}
+
+// Kind describes the disk's media: "NVMe", "SSD" or "HDD".
+func (d BlockDevice) Kind() string {
+	switch {
+	case d.NVMe:
+		return "NVMe"
+	case d.SSD:
+		return "SSD"
+	default:
+		return "HDD"
+	}
 }
 
 func (d BlockDevice) Path() string {
@@ -81,38 +128,59 @@ func (d BlockDevice) String() string {
 
 // Dotfile describes a file to copy into the installed system.
 type Dotfile struct {
-	Src  string // path relative to CWD
-	Dest string // destination path; ~ expands to /home/<username>
+	Src  string `json:"src"`  // path relative to CWD
+	Dest string `json:"dest"` // destination path; ~ expands to /home/<username>
+}
+
+// Hook is a user script run after an install phase.
+type Hook struct {
+	After  string `json:"after"`             // name of the phase it follows, e.g. "base_install"
+	Script string `json:"script"`            // path relative to CWD, or inside archy.zip
+	Chroot bool   `json:"chroot,omitempty"`  // run inside arch-chroot instead of the live environment
+	AsUser bool   `json:"as_user,omitempty"` // run as the created user; requires Chroot
+	Fatal  bool   `json:"fatal,omitempty"`   // fail the install when the script fails
 }
 
 // InstallConfig holds all user-selected values for the installation.
 type InstallConfig struct {
-	Device         BlockDevice
-	EFISize        string // e.g. "512M"
-	Encrypt        bool
-	LUKSPassphrase string
-	Hostname       string
-	Timezone       string
-	Username       string
-	UserPassword   string
-	RootPassword   string
-	ZRAMSize       string // e.g. "8G"
-	Desktop        DesktopEnvironment
-	Shell              string // "bash" or "zsh", empty means bash
-	SSHD               bool   // install and enable openssh
-	SSHPubKey          string // SSH public key content (from file or interactive)
-	Docker             bool   // install and enable docker
-	DockerGroup        bool   // add user to docker group
-	Dotfiles           []Dotfile
-	Packages           []string // additional pacman packages to install
-	AURPackages        []string // additional AUR packages to install via yay
-	BundleFS           fs.FS    // zip bundle filesystem, nil when using loose files
-	Mode               string   // "skip", "prompt", or "" (interactive)
-	EncryptSet         bool     // true when encrypt was explicitly set via config
-	DesktopSet         bool     // true when desktop was explicitly set via config
-	SSHDSet            bool     // true when sshd was explicitly set via config
-	SSHPubKeyFromConfig bool   // true when key was loaded from config file (requires APPROVE)
-	DockerSet          bool     // true when docker was explicitly set via config
+	Device              BlockDevice
+	EFISize             string // e.g. "512M"
+	Encrypt             bool
+	LUKSPassphrase      string
+	Hostname            string
+	Timezone            string
+	Username            string
+	UserPassword        string
+	RootPassword        string
+	ZRAMSize            string // e.g. "8G"
+	Desktop             DesktopEnvironment
+	Shell               string // "bash" or "zsh", empty means bash
+	SSHD                bool   // install and enable openssh
+	SSHPubKey           string // SSH public key content (from file or interactive)
+	Docker              bool   // install and enable docker
+	DockerGroup         bool   // add user to docker group
+	Dotfiles            []Dotfile
+	Hooks               []Hook       // scripts run after install phases, in order
+	Packages            []string     // additional pacman packages to install
+	AURPackages         []string     // additional AUR packages to install via yay
+	OfflineRepo         *OfflineRepo // install from this local repository only, nil to use the network
+	Mirrors             []string     // pacman Server URLs, tried first
+	MirrorCountries     []string     // countries to rank mirrors from with reflector
+	Partitions          []Partition  // disk layout, empty for an EFI and a root partition
+	Alongside           bool         // keep the disk's partitions, reuse its ESP and put root in free space
+	Filesystem          Filesystem   // root filesystem, btrfs by default
+	Subvolumes          []Subvolume  // btrfs layout, empty for DefaultSubvolumes
+	Mount               MountOptions // mount options for the root filesystem, shared by every subvolume
+	BundleFS            fs.FS        // zip bundle filesystem, nil when using loose files
+	Mode                string       // "skip", "prompt", or "" (interactive)
+	EncryptSet          bool         // true when encrypt was explicitly set via config
+	DesktopSet          bool         // true when desktop was explicitly set via config
+	SSHDSet             bool         // true when sshd was explicitly set via config
+	SSHPubKeyFromConfig bool         // true when key was loaded from config file (requires APPROVE)
+	DockerSet           bool         // true when docker was explicitly set via config
+	DryRun              bool         // record the install plan instead of touching disks
+	Resume              bool         // continue a failed install from its checkpoint
+	OnlyPhases          []string     // run just these phases (by name), empty means all
 }
 
 // PartitionPrefix returns the partition device prefix (handles NVMe "p" separator).
@@ -123,30 +191,79 @@ func (c *InstallConfig) PartitionPrefix() string {
 	return c.Device.Path()
 }
 
-// EFIPartition returns the path of the EFI partition (partition 1).
+// EFIPartition returns the path of the EFI system partition in the layout.
 func (c *InstallConfig) EFIPartition() string {
-	return c.PartitionPrefix() + "1"
+	dev, _ := c.findPartition(Partition.IsEFI)
+	return dev
 }
 
-// RootPartition returns the path of the root partition (partition 2).
+// RootPartition returns the path of the partition mounted at / in the layout.
 func (c *InstallConfig) RootPartition() string {
-	return c.PartitionPrefix() + "2"
+	dev, _ := c.findPartition(Partition.IsRoot)
+	return dev
 }
 
-// BtrfsDevice returns the device to format with btrfs — either the LUKS mapper
-// device or the raw root partition.
-func (c *InstallConfig) BtrfsDevice() string {
+// RootDevice returns the device the root filesystem is created on — either
+// the LUKS mapper device or the raw root partition.
+func (c *InstallConfig) RootDevice() string {
 	if c.Encrypt {
 		return "/dev/mapper/cryptroot"
 	}
 	return c.RootPartition()
 }
 
+// Missing returns the fields that have no value and would otherwise be
+// prompted for by the wizard, named as they are set in archy.toml or the
+// environment. Fields with defaults are never reported.
+func (c *InstallConfig) Missing() []string {
+	var missing []string
+	if c.Device.Name == "" {
+		missing = append(missing, "device")
+	}
+	if c.Hostname == "" {
+		missing = append(missing, "hostname")
+	}
+	if c.Timezone == "" {
+		missing = append(missing, "timezone")
+	}
+	if c.Username == "" {
+		missing = append(missing, "username")
+	}
+	if c.UserPassword == "" {
+		missing = append(missing, "ARCHY_USERPW")
+	}
+	if c.RootPassword == "" {
+		missing = append(missing, "ARCHY_ROOTPW")
+	}
+	if c.Encrypt && c.LUKSPassphrase == "" {
+		missing = append(missing, "ARCHY_PASSPHRASE")
+	}
+	return missing
+}
+
 // Summary returns a human-readable summary of the configuration for the confirm screen.
 func (c *InstallConfig) Summary() string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "Device:       %s\n", c.Device)
-	fmt.Fprintf(&b, "EFI Size:     %s\n", c.EFISize)
+	if c.Alongside {
+		fmt.Fprintf(&b, "Install Mode: alongside the existing partitions\n")
+		fmt.Fprintf(&b, "EFI:          %s (kept, not formatted)\n", c.EFIPartition())
+		if free, ok := c.Device.Table.LargestFree(); ok {
+			fmt.Fprintf(&b, "Root:         %s in %s of free space\n", c.RootPartition(), FormatBytes(c.Device.Table.Bytes(free.Sectors)))
+		}
+	} else if len(c.Partitions) > 0 {
+		var parts []string
+		for _, p := range c.Partitions {
+			size := p.Size
+			if size == "" {
+				size = "rest"
+			}
+			parts = append(parts, p.Name()+" "+size)
+		}
+		fmt.Fprintf(&b, "Partitions:   %s\n", strings.Join(parts, ", "))
+	} else {
+		fmt.Fprintf(&b, "EFI Size:     %s\n", c.EFISize)
+	}
 	fmt.Fprintf(&b, "Encryption:   %v\n", c.Encrypt)
 	if c.Encrypt {
 		fmt.Fprintf(&b, "Passphrase:   %s\n", strings.Repeat("*", len(c.LUKSPassphrase)))
@@ -181,8 +298,29 @@ func (c *InstallConfig) Summary() string {
 	if len(c.AURPackages) > 0 {
 		fmt.Fprintf(&b, "AUR Packages: %s\n", strings.Join(c.AURPackages, ", "))
 	}
+	if c.OfflineRepo != nil {
+		fmt.Fprintf(&b, "Offline Repo: %s\n", c.OfflineRepo.Dir)
+	}
+	if len(c.Mirrors) > 0 {
+		fmt.Fprintf(&b, "Mirrors:      %s\n", strings.Join(c.Mirrors, ", "))
+	}
+	if len(c.MirrorCountries) > 0 {
+		fmt.Fprintf(&b, "Countries:    %s\n", strings.Join(c.MirrorCountries, ", "))
+	}
+	fmt.Fprintf(&b, "Filesystem:   %s\n", c.Filesystem)
+	fmt.Fprintf(&b, "Mount Opts:   %s\n", c.RootMountOptions())
+	if len(c.Subvolumes) > 0 {
+		var names []string
+		for _, sv := range c.BtrfsSubvolumes() {
+			names = append(names, sv.Name+" "+sv.Mountpoint)
+		}
+		fmt.Fprintf(&b, "Subvolumes:   %s\n", strings.Join(names, ", "))
+	}
 	if len(c.Dotfiles) > 0 {
 		fmt.Fprintf(&b, "Dotfiles:     %d file(s)\n", len(c.Dotfiles))
 	}
+	if len(c.OnlyPhases) > 0 {
+		fmt.Fprintf(&b, "Only Phases:  %s\n", strings.Join(c.OnlyPhases, ", "))
+	}
 	return b.String()
 }
@@ -1,6 +1,9 @@
 package config
 
-import "testing"
+import (
+	"slices"
+	"testing"
+)
 
 func TestPartitionPrefix_SATA(t *testing.T) {
 	cfg := &InstallConfig{Device: BlockDevice{Name: "sda"}}
@@ -35,15 +38,15 @@ func TestPartitionPrefix_MMC(t *testing.T) {
 	}
 }
 
-func TestBtrfsDevice(t *testing.T) {
+func TestRootDevice(t *testing.T) {
 	cfg := &InstallConfig{Device: BlockDevice{Name: "sda"}, Encrypt: false}
-	if got := cfg.BtrfsDevice(); got != "/dev/sda2" {
-		t.Errorf("BtrfsDevice() = %q, want /dev/sda2", got)
+	if got := cfg.RootDevice(); got != "/dev/sda2" {
+		t.Errorf("RootDevice() = %q, want /dev/sda2", got)
 	}
 
 	cfg.Encrypt = true
-	if got := cfg.BtrfsDevice(); got != "/dev/mapper/cryptroot" {
-		t.Errorf("BtrfsDevice() = %q, want /dev/mapper/cryptroot", got)
+	if got := cfg.RootDevice(); got != "/dev/mapper/cryptroot" {
+		t.Errorf("RootDevice() = %q, want /dev/mapper/cryptroot", got)
 	}
 }
 
@@ -84,3 +87,47 @@ func TestDesktopEnvironment_DisplayManager(t *testing.T) {
 		t.Errorf("DesktopKDE.DisplayManager() = %q, want sddm", dm)
 	}
 }
+
+func TestParseFilesystem(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Filesystem
+		pkg  string
+	}{
+		{"btrfs", FilesystemBtrfs, "btrfs-progs"},
+		{"EXT4", FilesystemExt4, "e2fsprogs"},
+		{"xfs", FilesystemXFS, "xfsprogs"},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilesystem(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseFilesystem(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+		if pkgs := got.Packages(); len(pkgs) != 1 || pkgs[0] != tt.pkg {
+			t.Errorf("%v.Packages() = %v, want [%s]", got, pkgs, tt.pkg)
+		}
+	}
+	if _, err := ParseFilesystem("zfs"); err == nil {
+		t.Error("ParseFilesystem(zfs) = nil error, want error")
+	}
+}
+
+func TestMissing(t *testing.T) {
+	cfg := &InstallConfig{Encrypt: true}
+	want := []string{"device", "hostname", "timezone", "username", "ARCHY_USERPW", "ARCHY_ROOTPW", "ARCHY_PASSPHRASE"}
+	if got := cfg.Missing(); !slices.Equal(got, want) {
+		t.Errorf("Missing() = %v, want %v", got, want)
+	}
+
+	cfg = &InstallConfig{
+		Device:       BlockDevice{Name: "sda"},
+		Hostname:     "archbox",
+		Timezone:     "UTC",
+		Username:     "alice",
+		UserPassword: "userpw",
+		RootPassword: "rootpw",
+	}
+	if got := cfg.Missing(); len(got) != 0 {
+		t.Errorf("Missing() = %v, want none", got)
+	}
+}
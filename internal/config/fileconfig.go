@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -12,23 +13,55 @@ import (
 
 // tomlConfig is the raw decoded form of archy.toml.
 type tomlConfig struct {
-	Mode     string        `toml:"mode"`
-	Device   string        `toml:"device"`
-	EFISize  string        `toml:"efi_size"`
-	Encrypt  *bool         `toml:"encrypt"`
-	Hostname string        `toml:"hostname"`
-	Timezone string        `toml:"timezone"`
-	Username string        `toml:"username"`
-	ZRAMSize string        `toml:"zram_size"`
-	Desktop  string        `toml:"desktop"`
-	Shell        string        `toml:"shell"`
-	SSHD         *bool         `toml:"sshd"`
-	SSHPubKeyFile string       `toml:"ssh_pubkey_file"`
-	Docker       *bool         `toml:"docker"`
-	DockerGroup  *bool         `toml:"docker_group"`
-	Packages     []string      `toml:"packages"`
-	AURPackages  []string      `toml:"aur_packages"`
-	Dotfiles     []tomlDotfile `toml:"dotfiles"`
+	Mode            string          `toml:"mode"`
+	Device          string          `toml:"device"`
+	EFISize         string          `toml:"efi_size"`
+	Encrypt         *bool           `toml:"encrypt"`
+	Hostname        string          `toml:"hostname"`
+	Timezone        string          `toml:"timezone"`
+	Username        string          `toml:"username"`
+	ZRAMSize        string          `toml:"zram_size"`
+	Desktop         string          `toml:"desktop"`
+	Shell           string          `toml:"shell"`
+	SSHD            *bool           `toml:"sshd"`
+	SSHPubKeyFile   string          `toml:"ssh_pubkey_file"`
+	Docker          *bool           `toml:"docker"`
+	DockerGroup     *bool           `toml:"docker_group"`
+	Packages        []string        `toml:"packages"`
+	AURPackages     []string        `toml:"aur_packages"`
+	Dotfiles        []tomlDotfile   `toml:"dotfiles"`
+	Hooks           []tomlHook      `toml:"hooks"`
+	OfflineRepo     string          `toml:"offline_repo"`
+	Mirrors         []string        `toml:"mirrors"`
+	MirrorCountries []string        `toml:"mirror_countries"`
+	Filesystem      string          `toml:"filesystem"`
+	Partitions      []tomlPartition `toml:"partitions"`
+	Alongside       bool            `toml:"alongside"`
+	Subvolumes      []tomlSubvolume `toml:"subvolumes"`
+	Mount           tomlMount       `toml:"mount"`
+}
+
+type tomlMount struct {
+	Compress   string `toml:"compress"`
+	SSD        *bool  `toml:"ssd"`
+	Discard    string `toml:"discard"`
+	SpaceCache string `toml:"space_cache"`
+}
+
+type tomlPartition struct {
+	Size       string `toml:"size"`
+	Type       string `toml:"type"`
+	Label      string `toml:"label"`
+	Filesystem string `toml:"filesystem"`
+	Mountpoint string `toml:"mountpoint"`
+}
+
+type tomlSubvolume struct {
+	Name       string `toml:"name"`
+	Mountpoint string `toml:"mountpoint"`
+	Compress   string `toml:"compress"`
+	Options    string `toml:"options"`
+	NoDataCOW  bool   `toml:"nodatacow"`
 }
 
 type tomlDotfile struct {
@@ -36,6 +69,14 @@ type tomlDotfile struct {
 	Dest string `toml:"dest"`
 }
 
+type tomlHook struct {
+	After  string `toml:"after"`
+	Script string `toml:"script"`
+	Chroot bool   `toml:"chroot"`
+	AsUser bool   `toml:"as_user"`
+	Fatal  bool   `toml:"fatal"`
+}
+
 // LoadFileConfig loads configuration from archy.zip or archy.toml in the
 // current directory (if either exists), reads password environment variables,
 // validates all provided fields, and applies the results to cfg.
@@ -238,6 +279,127 @@ func applyTomlConfig(cfg *InstallConfig, tc *tomlConfig, disks []BlockDevice, ti
 	// AUR Packages
 	cfg.AURPackages = append(cfg.AURPackages, tc.AURPackages...)
 
+	// Offline repository
+	if tc.OfflineRepo != "" {
+		var bundlePath string
+		if bundle != nil {
+			bundlePath, _ = filepath.Abs("archy.zip")
+		}
+		repo, err := ParseOfflineRepo(tc.OfflineRepo, bundlePath)
+		if err != nil {
+			return fmt.Errorf("archy.toml: offline_repo: %w", err)
+		}
+		ix, err := repo.Index(bundle)
+		if err != nil {
+			return fmt.Errorf("archy.toml: offline_repo: %w", err)
+		}
+		if missing := ix.Missing(append(cfg.Desktop.Packages(), cfg.Packages...)); len(missing) > 0 {
+			return fmt.Errorf("archy.toml: packages not in offline repository %s: %s", repo.Dir, strings.Join(missing, ", "))
+		}
+		if len(cfg.AURPackages) > 0 {
+			return fmt.Errorf("archy.toml: aur_packages cannot be installed with offline_repo (the AUR needs network access)")
+		}
+		cfg.OfflineRepo = repo
+	}
+
+	// Mirrors
+	for _, m := range tc.Mirrors {
+		if err := ValidateMirror(m); err != nil {
+			return fmt.Errorf("archy.toml: mirrors: %w", err)
+		}
+	}
+	for _, c := range tc.MirrorCountries {
+		if err := ValidateMirrorCountry(c); err != nil {
+			return fmt.Errorf("archy.toml: mirror_countries: %w", err)
+		}
+	}
+	if cfg.OfflineRepo != nil && len(tc.Mirrors)+len(tc.MirrorCountries) > 0 {
+		return fmt.Errorf("archy.toml: mirrors and mirror_countries cannot be combined with offline_repo")
+	}
+	cfg.Mirrors = tc.Mirrors
+	cfg.MirrorCountries = tc.MirrorCountries
+
+	// Filesystem
+	if tc.Filesystem != "" {
+		f, err := ParseFilesystem(tc.Filesystem)
+		if err != nil {
+			return fmt.Errorf("archy.toml: %w", err)
+		}
+		cfg.Filesystem = f
+	}
+
+	// Partitions
+	if len(tc.Partitions) > 0 {
+		if tc.EFISize != "" {
+			return fmt.Errorf("archy.toml: efi_size cannot be combined with partitions: give the EFI partition a size instead")
+		}
+		var parts []Partition
+		for _, p := range tc.Partitions {
+			parts = append(parts, Partition{Size: p.Size, Type: p.Type, Label: p.Label, Filesystem: strings.ToLower(p.Filesystem), Mountpoint: p.Mountpoint})
+		}
+		if err := ValidatePartitions(parts); err != nil {
+			return fmt.Errorf("archy.toml: partitions: %w", err)
+		}
+		// The root partition's filesystem is the root filesystem
+		for _, p := range parts {
+			if !p.IsRoot() || p.Filesystem == "" {
+				continue
+			}
+			f, err := ParseFilesystem(p.Filesystem)
+			if err != nil {
+				return fmt.Errorf("archy.toml: partitions: %w", err)
+			}
+			if tc.Filesystem != "" && f != cfg.Filesystem {
+				return fmt.Errorf("archy.toml: the root partition is %s but filesystem is %q", f, cfg.Filesystem)
+			}
+			cfg.Filesystem = f
+		}
+		cfg.Partitions = parts
+	}
+
+	// Alongside other systems
+	if tc.Alongside {
+		switch {
+		case len(tc.Partitions) > 0:
+			return fmt.Errorf("archy.toml: alongside keeps the disk's partitions and cannot be combined with partitions")
+		case tc.EFISize != "":
+			return fmt.Errorf("archy.toml: alongside reuses the disk's EFI partition and cannot be combined with efi_size")
+		}
+		// A resumed install has taken the free space already and uses the
+		// partition table recorded in its checkpoint
+		if cfg.Device.Name != "" && !cfg.Resume {
+			if err := cfg.Device.Table.CheckAlongside(); err != nil {
+				return fmt.Errorf("archy.toml: alongside: %s: %w", cfg.Device.Path(), err)
+			}
+		}
+		cfg.Alongside = true
+	}
+
+	// Subvolumes
+	if len(tc.Subvolumes) > 0 && cfg.Filesystem != FilesystemBtrfs {
+		return fmt.Errorf("archy.toml: subvolumes need filesystem = \"btrfs\", not %q", cfg.Filesystem)
+	}
+	if len(tc.Subvolumes) > 0 {
+		var subvols []Subvolume
+		for _, sv := range tc.Subvolumes {
+			subvols = append(subvols, Subvolume{Name: sv.Name, Mountpoint: sv.Mountpoint, Compress: sv.Compress, Options: sv.Options, NoDataCOW: sv.NoDataCOW})
+		}
+		if err := ValidateSubvolumes(subvols); err != nil {
+			return fmt.Errorf("archy.toml: subvolumes: %w", err)
+		}
+		cfg.Subvolumes = subvols
+	}
+	if err := cfg.ValidateMountpoints(); err != nil {
+		return fmt.Errorf("archy.toml: %w", err)
+	}
+
+	// Mount options
+	mount := MountOptions{Compress: tc.Mount.Compress, SSD: tc.Mount.SSD, Discard: tc.Mount.Discard, SpaceCache: tc.Mount.SpaceCache}
+	if err := ValidateMountOptions(mount, cfg.Filesystem); err != nil {
+		return fmt.Errorf("archy.toml: mount: %w", err)
+	}
+	cfg.Mount = mount
+
 	// Dotfiles
 	for _, df := range tc.Dotfiles {
 		if df.Src == "" {
@@ -258,6 +420,29 @@ func applyTomlConfig(cfg *InstallConfig, tc *tomlConfig, disks []BlockDevice, ti
 		cfg.Dotfiles = append(cfg.Dotfiles, Dotfile{Src: df.Src, Dest: df.Dest})
 	}
 
+	// Hooks
+	for _, h := range tc.Hooks {
+		if h.After == "" {
+			return fmt.Errorf("archy.toml: hook entry missing after")
+		}
+		if h.Script == "" {
+			return fmt.Errorf("archy.toml: hook entry missing script")
+		}
+		if h.AsUser && !h.Chroot {
+			return fmt.Errorf("archy.toml: hook %q: as_user requires chroot = true", h.Script)
+		}
+		if bundle != nil {
+			if _, err := fs.Stat(bundle, h.Script); err != nil {
+				return fmt.Errorf("archy.toml: hook script %q not found in archy.zip", h.Script)
+			}
+		} else {
+			if _, err := os.Stat(h.Script); err != nil {
+				return fmt.Errorf("archy.toml: hook script %q: %w", h.Script, err)
+			}
+		}
+		cfg.Hooks = append(cfg.Hooks, Hook{After: h.After, Script: h.Script, Chroot: h.Chroot, AsUser: h.AsUser, Fatal: h.Fatal})
+	}
+
 	return nil
 }
 
@@ -279,6 +464,20 @@ func ParseDesktopEnvironment(s string) (DesktopEnvironment, error) {
 	}
 }
 
+// ParseFilesystem converts a string to a Filesystem value.
+func ParseFilesystem(s string) (Filesystem, error) {
+	switch strings.ToLower(s) {
+	case "btrfs":
+		return FilesystemBtrfs, nil
+	case "ext4":
+		return FilesystemExt4, nil
+	case "xfs":
+		return FilesystemXFS, nil
+	default:
+		return FilesystemBtrfs, fmt.Errorf("invalid filesystem %q: must be one of btrfs, ext4, xfs", s)
+	}
+}
+
 func findDisk(name string, disks []BlockDevice) (BlockDevice, bool) {
 	for _, d := range disks {
 		if d.Name == name || d.Path() == name {
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PartitionTable is the partition table already on a disk, as read by
+// sfdisk.
+type PartitionTable struct {
+	Label      string          `json:"label"` // "gpt" or "dos"
+	SectorSize int64           `json:"sector_size"`
+	FirstLBA   int64           `json:"first_lba"` // first usable sector
+	LastLBA    int64           `json:"last_lba"`  // last usable sector
+	Partitions []DiskPartition `json:"partitions"`
+}
+
+// DiskPartition is a partition already on a disk.
+type DiskPartition struct {
+	Node    string `json:"node"` // e.g. "/dev/sda1"
+	Number  int    `json:"number"`
+	Start   int64  `json:"start"` // first sector
+	Sectors int64  `json:"sectors"`
+	Type    string `json:"type"`           // GPT type GUID
+	Name    string `json:"name,omitempty"` // GPT partition name
+}
+
+// FreeRegion is unallocated space on a disk.
+type FreeRegion struct {
+	Start   int64 // first sector
+	Sectors int64
+}
+
+// End returns the last sector of the region.
+func (r FreeRegion) End() int64 {
+	return r.Start + r.Sectors - 1
+}
+
+type sfdiskOutput struct {
+	PartitionTable struct {
+		Label      string `json:"label"`
+		SectorSize int64  `json:"sectorsize"`
+		FirstLBA   int64  `json:"firstlba"`
+		LastLBA    int64  `json:"lastlba"`
+		Partitions []struct {
+			Node  string `json:"node"`
+			Start int64  `json:"start"`
+			Size  int64  `json:"size"`
+			Type  string `json:"type"`
+			Name  string `json:"name"`
+		} `json:"partitions"`
+	} `json:"partitiontable"`
+}
+
+// ParsePartitionTable decodes the output of sfdisk --json.
+func ParsePartitionTable(data []byte) (*PartitionTable, error) {
+	var out sfdiskOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("sfdisk: %w", err)
+	}
+	pt := out.PartitionTable
+	t := &PartitionTable{Label: pt.Label, SectorSize: pt.SectorSize, FirstLBA: pt.FirstLBA, LastLBA: pt.LastLBA}
+	if t.SectorSize == 0 {
+		t.SectorSize = 512
+	}
+	for _, p := range pt.Partitions {
+		i := strings.LastIndexFunc(p.Node, func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(p.Node[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("sfdisk: no partition number in %q", p.Node)
+		}
+		t.Partitions = append(t.Partitions, DiskPartition{
+			Node: p.Node, Number: n, Start: p.Start, Sectors: p.Size, Type: strings.ToUpper(p.Type), Name: p.Name,
+		})
+	}
+	slices.SortFunc(t.Partitions, func(a, b DiskPartition) int { return cmp.Compare(a.Start, b.Start) })
+	return t, nil
+}
+
+// Bytes returns the size of the given number of sectors.
+func (t *PartitionTable) Bytes(sectors int64) int64 {
+	return sectors * t.SectorSize
+}
+
+// ESP returns the disk's EFI system partition, if it has one.
+func (t *PartitionTable) ESP() (DiskPartition, bool) {
+	if t == nil {
+		return DiskPartition{}, false
+	}
+	for _, p := range t.Partitions {
+		if p.Type == efiGUID {
+			return p, true
+		}
+	}
+	return DiskPartition{}, false
+}
+
+// Free returns the unallocated regions of at least 1 MiB, in disk order,
+// each starting on a 1 MiB boundary as partitioning tools align them.
+func (t *PartitionTable) Free() []FreeRegion {
+	if t == nil {
+		return nil
+	}
+	align := max(1<<20/t.SectorSize, 1)
+	up := func(s int64) int64 { return (s + align - 1) / align * align }
+	var free []FreeRegion
+	add := func(start, end int64) {
+		if start = up(start); end-start+1 >= align {
+			free = append(free, FreeRegion{start, end - start + 1})
+		}
+	}
+	next := t.FirstLBA
+	for _, p := range t.Partitions {
+		if p.Start > next {
+			add(next, p.Start-1)
+		}
+		next = max(next, p.Start+p.Sectors)
+	}
+	if next <= t.LastLBA {
+		add(next, t.LastLBA)
+	}
+	return free
+}
+
+// LargestFree returns the largest unallocated region, where an install
+// alongside the existing partitions puts its root partition.
+func (t *PartitionTable) LargestFree() (FreeRegion, bool) {
+	var largest FreeRegion
+	for _, r := range t.Free() {
+		if r.Sectors > largest.Sectors {
+			largest = r
+		}
+	}
+	return largest, largest.Sectors > 0
+}
+
+// NextNumber returns the lowest partition number not in use.
+func (t *PartitionTable) NextNumber() int {
+	if t == nil {
+		return 1
+	}
+	for n := 1; ; n++ {
+		if !slices.ContainsFunc(t.Partitions, func(p DiskPartition) bool { return p.Number == n }) {
+			return n
+		}
+	}
+}
+
+// CheckAlongside reports why archy cannot install alongside the partitions
+// in t: a GPT with an EFI system partition to reuse and some free space is
+// needed. A nil table is a disk without one.
+func (t *PartitionTable) CheckAlongside() error {
+	switch {
+	case t == nil:
+		return errors.New("the disk has no partition table to install alongside")
+	case t.Label != "gpt":
+		return fmt.Errorf("the disk has a %s partition table, not GPT", t.Label)
+	}
+	if _, ok := t.ESP(); !ok {
+		return errors.New("the disk has no EFI system partition to reuse")
+	}
+	if _, ok := t.LargestFree(); !ok {
+		return errors.New("the disk has no unallocated space")
+	}
+	return nil
+}
+
+// FormatBytes returns n in MiB or GiB, as partitioning tools show sizes.
+func FormatBytes(n int64) string {
+	if n < 1<<30 {
+		return fmt.Sprintf("%d MiB", n>>20)
+	}
+	return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+}
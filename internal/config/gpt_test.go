@@ -0,0 +1,117 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// windowsTable is sfdisk --json for a 512 GiB NVMe disk holding Windows,
+// with 908 MiB free before its data partition and 310.5 GiB after it.
+const windowsTable = `{
+   "partitiontable": {
+      "label": "gpt",
+      "id": "5A1D6E0E-0F5B-4A8C-9E51-2B2F1C0E7A11",
+      "device": "/dev/nvme0n1",
+      "unit": "sectors",
+      "firstlba": 2048,
+      "lastlba": 1073741790,
+      "sectorsize": 512,
+      "partitions": [
+         {"node": "/dev/nvme0n1p1", "start": 2048, "size": 204800, "type": "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", "name": "EFI system partition"},
+         {"node": "/dev/nvme0n1p2", "start": 206848, "size": 32768, "type": "E3C9E316-0B5C-4DB8-817D-F92DF00215AE", "name": "Microsoft reserved partition"},
+         {"node": "/dev/nvme0n1p4", "start": 1072693248, "size": 1046528, "type": "DE94BBA4-06D1-4D40-A16A-BFD50179D6AC"},
+         {"node": "/dev/nvme0n1p3", "start": 2099200, "size": 419430400, "type": "ebd0a0a2-b9e5-4433-87c0-68b6b72699c7", "name": "Basic data partition"}
+      ]
+   }
+}`
+
+func TestParsePartitionTable(t *testing.T) {
+	table, err := ParsePartitionTable([]byte(windowsTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers []int
+	for _, p := range table.Partitions {
+		numbers = append(numbers, p.Number)
+	}
+	if !slices.Equal(numbers, []int{1, 2, 3, 4}) {
+		t.Errorf("partitions in disk order = %v, want [1 2 3 4]", numbers)
+	}
+	if p := table.Partitions[2]; p.Type != "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7" || p.Name != "Basic data partition" {
+		t.Errorf("partition 3 = %+v", p)
+	}
+	if esp, ok := table.ESP(); !ok || esp.Node != "/dev/nvme0n1p1" {
+		t.Errorf("ESP() = %+v, %v, want /dev/nvme0n1p1", esp, ok)
+	}
+
+	// The 2015 sectors after the recovery partition are less than 1 MiB
+	want := []FreeRegion{{239616, 1859584}, {421529600, 651163648}}
+	if got := table.Free(); !slices.Equal(got, want) {
+		t.Errorf("Free() = %v, want %v", got, want)
+	}
+	if got, ok := table.LargestFree(); !ok || got != want[1] || got.End() != 1072693247 {
+		t.Errorf("LargestFree() = %v, %v, want %v", got, ok, want[1])
+	}
+	if got := FormatBytes(table.Bytes(want[1].Sectors)); got != "310.5 GiB" {
+		t.Errorf("FormatBytes = %q, want 310.5 GiB", got)
+	}
+	if got := FormatBytes(table.Bytes(want[0].Sectors)); got != "908 MiB" {
+		t.Errorf("FormatBytes = %q, want 908 MiB", got)
+	}
+	if got := table.NextNumber(); got != 5 {
+		t.Errorf("NextNumber() = %d, want 5", got)
+	}
+	if err := table.CheckAlongside(); err != nil {
+		t.Errorf("CheckAlongside() = %v, want nil", err)
+	}
+
+	if _, err := ParsePartitionTable([]byte(`{"partitiontable": {"partitions": [{"node": "/dev/sda"}]}}`)); err == nil {
+		t.Error("ParsePartitionTable accepted a partition without a number")
+	}
+}
+
+func TestCheckAlongside(t *testing.T) {
+	esp := DiskPartition{Node: "/dev/sda1", Number: 1, Start: 2048, Sectors: 204800, Type: efiGUID}
+	data := DiskPartition{Node: "/dev/sda2", Number: 2, Start: 206848, Sectors: 1 << 20, Type: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"}
+	tests := []struct {
+		table *PartitionTable
+		want  string
+	}{
+		{nil, "no partition table"},
+		{&PartitionTable{Label: "dos", SectorSize: 512, LastLBA: 1 << 30}, "dos partition table"},
+		{&PartitionTable{Label: "gpt", SectorSize: 512, FirstLBA: 2048, LastLBA: 1 << 30, Partitions: []DiskPartition{data}}, "no EFI system partition"},
+		{&PartitionTable{Label: "gpt", SectorSize: 512, FirstLBA: 2048, LastLBA: 206848 + 1<<20 - 1, Partitions: []DiskPartition{esp, data}}, "no unallocated space"},
+	}
+	for _, tt := range tests {
+		err := tt.table.CheckAlongside()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("CheckAlongside(%+v) = %v, want error containing %q", tt.table, err, tt.want)
+		}
+	}
+}
+
+func TestAlongsideLayout(t *testing.T) {
+	table, err := ParsePartitionTable([]byte(windowsTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &InstallConfig{Device: BlockDevice{Name: "nvme0n1", Table: table}, EFISize: "512M", Alongside: true}
+	if got := cfg.EFIPartition(); got != "/dev/nvme0n1p1" {
+		t.Errorf("EFIPartition() = %q, want the existing /dev/nvme0n1p1", got)
+	}
+	if got := cfg.RootPartition(); got != "/dev/nvme0n1p5" {
+		t.Errorf("RootPartition() = %q, want /dev/nvme0n1p5", got)
+	}
+	if got := cfg.EFIMountpoint(); got != "/efi" {
+		t.Errorf("EFIMountpoint() = %q, want /efi", got)
+	}
+	if layout := cfg.PartitionLayout(); !layout[0].Existing || layout[1].Existing {
+		t.Errorf("layout = %+v, want the EFI partition kept and root created", layout)
+	}
+	// Kernels stay on the root filesystem, so /boot may be a subvolume
+	cfg.Subvolumes = []Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@boot", Mountpoint: "/boot"}}
+	if err := cfg.ValidateMountpoints(); err != nil {
+		t.Errorf("ValidateMountpoints() = %v, want a /boot subvolume allowed beside the ESP at /efi", err)
+	}
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// OfflineRepo is a local pacman repository that replaces the network
+// repositories for the whole install.
+type OfflineRepo struct {
+	Name   string // repository (and database) name, e.g. "archy" for archy.db
+	Dir    string // directory holding the database and packages
+	Bundle string // absolute path of the archy.zip that holds Dir, "" when Dir is on the live system
+
+	Database string // database file in Dir, e.g. "archy.db.tar.gz", set by Index
+}
+
+// ParseOfflineRepo resolves the offline_repo setting. A file:// URL or
+// absolute path names a directory on the live system (a USB stick, say); a
+// relative path is looked up in the bundle, or the current directory when
+// there is none.
+func ParseOfflineRepo(s string, bundle string) (*OfflineRepo, error) {
+	dir := strings.TrimPrefix(s, "file://")
+	if dir == "" {
+		return nil, fmt.Errorf("offline repository path is empty")
+	}
+	repo := &OfflineRepo{Dir: path.Clean(dir)}
+	if !path.IsAbs(dir) {
+		if strings.Contains(s, "://") {
+			return nil, fmt.Errorf("offline repository %q: only file:// URLs are supported", s)
+		}
+		if bundle != "" {
+			repo.Bundle = bundle
+		} else {
+			wd, err := os.Getwd()
+			if err != nil {
+				return nil, err
+			}
+			repo.Dir = path.Join(wd, repo.Dir)
+		}
+	}
+	return repo, nil
+}
+
+// FS returns the repository directory. bundle is the opened archy.zip when
+// the repository is inside it.
+func (r *OfflineRepo) FS(bundle fs.FS) (fs.FS, error) {
+	if r.Bundle == "" {
+		return os.DirFS(r.Dir), nil
+	}
+	if bundle == nil {
+		return nil, fmt.Errorf("offline repository %s is in %s, which is not open", r.Dir, r.Bundle)
+	}
+	return fs.Sub(bundle, r.Dir)
+}
+
+// RepoIndex is what a pacman repository database offers.
+type RepoIndex struct {
+	packages map[string]bool // package names and what they provide
+	groups   map[string]bool
+}
+
+// Has reports whether name can be installed from the repository, as a
+// package, a group or something a package provides.
+func (ix *RepoIndex) Has(name string) bool {
+	return ix.packages[name] || ix.groups[name]
+}
+
+// Missing returns the names in pkgs the repository cannot install.
+func (ix *RepoIndex) Missing(pkgs []string) []string {
+	var missing []string
+	for _, p := range pkgs {
+		if !ix.Has(p) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// Index reads the repository database, setting r.Name from its file name.
+// pacman only reads NAME.db, which repo-add writes as a link to
+// NAME.db.tar.gz. zip files do not keep links, so in a bundle either is
+// accepted and the installer recreates NAME.db after unpacking; a directory
+// on the live system must have NAME.db. The database must be gzip-compressed
+// or uncompressed.
+func (r *OfflineRepo) Index(bundle fs.FS) (*RepoIndex, error) {
+	fsys, err := r.FS(bundle)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadIndex(fsys)
+}
+
+// ReadIndex is Index with the repository directory given as fsys.
+func (r *OfflineRepo) ReadIndex(fsys fs.FS) (*RepoIndex, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("offline repository %s: %w", r.Dir, err)
+	}
+	var db string
+	for _, e := range entries {
+		for _, ext := range []string{".db", ".db.tar.gz", ".db.tar"} {
+			if name, ok := strings.CutSuffix(e.Name(), ext); ok && (db == "" || ext == ".db") {
+				r.Name, db = name, e.Name()
+			}
+		}
+	}
+	if db == "" {
+		return nil, fmt.Errorf("offline repository %s has no database (create one with repo-add NAME.db.tar.gz *.pkg.tar.zst)", r.Dir)
+	}
+	if db != r.Name+".db" && r.Bundle == "" {
+		return nil, fmt.Errorf("offline repository %s has %s but no %s.db, which pacman reads: copy or link it there", r.Dir, db, r.Name)
+	}
+	r.Database = db
+	data, err := fs.ReadFile(fsys, db)
+	if err != nil {
+		return nil, fmt.Errorf("offline repository %s: %w", r.Dir, err)
+	}
+	ix, err := parseRepoDB(data)
+	if err != nil {
+		return nil, fmt.Errorf("offline repository database %s: %w", db, err)
+	}
+	return ix, nil
+}
+
+// parseRepoDB reads the desc file of every package in a repository database.
+func parseRepoDB(data []byte) (*RepoIndex, error) {
+	var rd io.Reader = bytes.NewReader(data)
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(rd)
+		if err != nil {
+			return nil, err
+		}
+		rd = zr
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, errors.New("zstd-compressed databases are not supported: rebuild it with repo-add NAME.db.tar.gz")
+	}
+
+	ix := &RepoIndex{packages: map[string]bool{}, groups: map[string]bool{}}
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Base(hdr.Name) != "desc" {
+			continue
+		}
+		var section string
+		sc := bufio.NewScanner(tr)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			switch {
+			case line == "":
+				section = ""
+			case strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%"):
+				section = line
+			case section == "%NAME%":
+				ix.packages[line] = true
+			case section == "%PROVIDES%":
+				// Provides may carry a version, as in "sh=5.2"
+				name, _, _ := strings.Cut(line, "=")
+				ix.packages[name] = true
+			case section == "%GROUPS%":
+				ix.groups[line] = true
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(ix.packages) == 0 {
+		return nil, errors.New("no packages")
+	}
+	return ix, nil
+}
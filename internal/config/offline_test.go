@@ -0,0 +1,123 @@
+package config
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// repoDB builds a gzip-compressed repository database from desc file
+// contents, keyed by package directory.
+func repoDB(t *testing.T, descs map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for dir, desc := range descs {
+		if err := tw.WriteHeader(&tar.Header{Name: dir + "/desc", Mode: 0o644, Size: int64(len(desc))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(desc))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+var testDescs = map[string]string{
+	"bash-5.2.037-1":  "%NAME%\nbash\n\n%PROVIDES%\nsh=5.2\n",
+	"gdm-47.0-1":      "%NAME%\ngdm\n\n%GROUPS%\ngnome\n",
+	"linux-6.12.1-1":  "%NAME%\nlinux\n\n%VERSION%\n6.12.1-1\n",
+	"openssh-9.9p1-2": "%NAME%\nopenssh\n",
+}
+
+func TestOfflineRepoIndex(t *testing.T) {
+	bundle := fstest.MapFS{
+		"repo/x86_64/archy.db.tar.gz":                   {Data: repoDB(t, testDescs)},
+		"repo/x86_64/linux-6.12.1-1-x86_64.pkg.tar.zst": {Data: []byte("pkg")},
+	}
+	repo := &OfflineRepo{Dir: "repo/x86_64", Bundle: "/root/archy.zip"}
+	ix, err := repo.Index(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.Name != "archy" || repo.Database != "archy.db.tar.gz" {
+		t.Errorf("Name = %q, Database = %q, want archy from archy.db.tar.gz", repo.Name, repo.Database)
+	}
+	for _, name := range []string{"bash", "sh", "gnome", "linux"} {
+		if !ix.Has(name) {
+			t.Errorf("Has(%q) = false, want true", name)
+		}
+	}
+	if got := ix.Missing([]string{"linux", "vim", "sh", "gnome-shell"}); !slices.Equal(got, []string{"vim", "gnome-shell"}) {
+		t.Errorf("Missing = %v, want [vim gnome-shell]", got)
+	}
+}
+
+func TestOfflineRepoIndexErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"no database", fstest.MapFS{"repo/linux.pkg.tar.zst": {}}, "no database"},
+		{"zstd", fstest.MapFS{"repo/archy.db": {Data: []byte{0x28, 0xb5, 0x2f, 0xfd, 0}}}, "zstd"},
+		{"empty", fstest.MapFS{"repo/archy.db": {Data: repoDB(t, nil)}}, "no packages"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &OfflineRepo{Dir: "repo", Bundle: "/root/archy.zip"}
+			_, err := repo.Index(tt.files)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Index error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOfflineRepoNeedsDBOnLiveSystem(t *testing.T) {
+	// pacman fetches only archy.db, which a bundle gets when it is unpacked
+	// but a directory on the live system must already have
+	dir := fstest.MapFS{"archy.db.tar.gz": {Data: repoDB(t, testDescs)}}
+	repo := &OfflineRepo{Dir: "/run/media/usb/repo"}
+	if _, err := repo.ReadIndex(dir); err == nil || !strings.Contains(err.Error(), "no archy.db") {
+		t.Errorf("ReadIndex error = %v, want missing archy.db", err)
+	}
+
+	dir["archy.db"] = dir["archy.db.tar.gz"]
+	if _, err := repo.ReadIndex(dir); err != nil || repo.Database != "archy.db" {
+		t.Errorf("ReadIndex = %v, Database = %q, want archy.db", err, repo.Database)
+	}
+}
+
+func TestParseOfflineRepo(t *testing.T) {
+	tests := []struct {
+		in, bundle string
+		want       OfflineRepo
+		wantErr    bool
+	}{
+		{in: "file:///run/media/usb/repo/", want: OfflineRepo{Dir: "/run/media/usb/repo"}},
+		{in: "/srv/repo", bundle: "/root/archy.zip", want: OfflineRepo{Dir: "/srv/repo"}},
+		{in: "repo/x86_64", bundle: "/root/archy.zip", want: OfflineRepo{Dir: "repo/x86_64", Bundle: "/root/archy.zip"}},
+		{in: "https://mirror.example.com/repo", wantErr: true},
+		{in: "file://", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOfflineRepo(tt.in, tt.bundle)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseOfflineRepo(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || *got != tt.want {
+			t.Errorf("ParseOfflineRepo(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
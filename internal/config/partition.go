@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeCodeRe = regexp.MustCompile(`^[0-9A-Fa-f]{4}$`)
+	typeGUIDRe = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+	labelRe    = regexp.MustCompile(`^[A-Za-z0-9_.+-]{1,36}$`)
+)
+
+// efiGUID is the GPT type of an EFI system partition.
+const efiGUID = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+
+// partitionTypes are the aliases accepted for a partition's type, and the
+// sgdisk type codes they stand for.
+var partitionTypes = map[string]string{
+	"efi":      "ef00",
+	"linux":    "8300",
+	"root":     "8304", // Linux x86-64 root
+	"home":     "8302",
+	"swap":     "8200",
+	"xbootldr": "ea00",
+}
+
+// partitionFilesystems are the filesystems a partition can be formatted with.
+var partitionFilesystems = []string{"vfat", "btrfs", "ext4", "xfs", "swap"}
+
+// Partition is an entry of the disk layout. Partitions are created in order,
+// so the first is partition 1.
+type Partition struct {
+	Size       string `json:"size,omitempty"`       // e.g. "512M"; empty for the rest of the disk, last partition only
+	Type       string `json:"type"`                 // alias such as "efi", sgdisk type code or GPT type GUID
+	Label      string `json:"label,omitempty"`      // GPT partition name
+	Filesystem string `json:"filesystem,omitempty"` // vfat, btrfs, ext4, xfs or swap; empty leaves it unformatted
+	Mountpoint string `json:"mountpoint,omitempty"` // absolute path in the installed system
+	Number     int    `json:"number"`               // partition number, set by PartitionLayout
+	Existing   bool   `json:"existing,omitempty"`   // already on the disk: neither created nor formatted
+}
+
+// TypeCode returns the partition's type as sgdisk takes it.
+func (p Partition) TypeCode() string {
+	if code, ok := partitionTypes[strings.ToLower(p.Type)]; ok {
+		return code
+	}
+	return p.Type
+}
+
+// IsEFI reports whether p is an EFI system partition.
+func (p Partition) IsEFI() bool {
+	code := p.TypeCode()
+	return strings.EqualFold(code, "ef00") || strings.EqualFold(code, efiGUID)
+}
+
+// IsRoot reports whether p holds the root filesystem.
+func (p Partition) IsRoot() bool {
+	return p.Mountpoint == "/"
+}
+
+// Name describes p in log messages: "EFI", "root", "swap", its mountpoint
+// or, for a partition that is not mounted, its label or filesystem.
+func (p Partition) Name() string {
+	switch {
+	case p.IsEFI():
+		return "EFI"
+	case p.IsRoot():
+		return "root"
+	case p.Filesystem == "swap":
+		return "swap"
+	case p.Mountpoint != "":
+		return p.Mountpoint
+	case p.Label != "":
+		return p.Label
+	case p.Filesystem != "":
+		return p.Filesystem
+	}
+	return "unformatted"
+}
+
+// ValidatePartitions checks a complete partition layout: sizes are valid and
+// only the last partition takes the rest of the disk, types are known, every
+// mountpoint is a distinct clean path, and there is exactly one root and one
+// EFI system partition. Whether the sizes fit the disk is checked before the
+// install, when the disk's exact size is known.
+func ValidatePartitions(parts []Partition) error {
+	if len(parts) > 128 {
+		return fmt.Errorf("%d partitions: GPT holds at most 128", len(parts))
+	}
+	mountpoints := map[string]bool{}
+	var roots, efis int
+	for i, p := range parts {
+		n := "partition " + strconv.Itoa(i+1)
+		if p.Size == "" {
+			if i != len(parts)-1 {
+				return fmt.Errorf("%s: only the last partition may leave out size to take the rest of the disk", n)
+			}
+		} else if err := ValidatePartitionSize(p.Size); err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+
+		code := p.TypeCode()
+		if !typeCodeRe.MatchString(code) && !typeGUIDRe.MatchString(code) {
+			return fmt.Errorf("%s: invalid type %q: use a type code such as 8300, a GUID or one of efi, linux, root, home, swap, xbootldr", n, p.Type)
+		}
+		if p.Label != "" && !labelRe.MatchString(p.Label) {
+			return fmt.Errorf("%s: invalid label %q: use up to 36 letters, digits and _.+-", n, p.Label)
+		}
+		if p.Filesystem != "" && !slices.Contains(partitionFilesystems, p.Filesystem) {
+			return fmt.Errorf("%s: invalid filesystem %q: must be one of %s", n, p.Filesystem, strings.Join(partitionFilesystems, ", "))
+		}
+
+		mp := p.Mountpoint
+		switch {
+		case mp == "":
+		case p.Filesystem == "swap":
+			return fmt.Errorf("%s: swap cannot have a mountpoint", n)
+		case !path.IsAbs(mp) || path.Clean(mp) != mp || strings.ContainsAny(mp, " \t"):
+			return fmt.Errorf("%s: mountpoint %q must be a clean absolute path without spaces", n, mp)
+		case mountpoints[mp]:
+			return fmt.Errorf("%s: %s is already mounted from another partition", n, mp)
+		case p.Filesystem == "" && !p.IsRoot() && !p.IsEFI():
+			return fmt.Errorf("%s: mounting %s needs a filesystem", n, mp)
+		}
+		if mp != "" {
+			mountpoints[mp] = true
+		}
+
+		if p.IsRoot() {
+			roots++
+			if p.Filesystem == "vfat" || p.Filesystem == "swap" {
+				return fmt.Errorf("%s: the root filesystem cannot be %s", n, p.Filesystem)
+			}
+		}
+		if p.IsEFI() {
+			efis++
+			if p.Filesystem != "" && p.Filesystem != "vfat" {
+				return fmt.Errorf("%s: the EFI system partition must be vfat, not %s", n, p.Filesystem)
+			}
+			if mp == "" || p.IsRoot() {
+				return fmt.Errorf("%s: the EFI system partition needs a mountpoint such as /boot or /efi", n)
+			}
+		}
+	}
+	if roots != 1 {
+		return fmt.Errorf("the layout has %d partitions mounted at /, want exactly one", roots)
+	}
+	if efis != 1 {
+		return fmt.Errorf("the layout has %d EFI system partitions, want exactly one", efis)
+	}
+	return nil
+}
+
+// PartitionLayout returns the partitions of the install, numbered: the
+// configured layout, or an EFI partition of EFISize mounted at /boot
+// followed by a root partition filling the rest of the disk.
+//
+// Alongside other systems, the disk's EFI system partition is kept and
+// mounted at /efi, as it is often too small for kernels, and root takes the
+// lowest free partition number. Its place on the disk is up to the
+// installer.
+func (c *InstallConfig) PartitionLayout() []Partition {
+	if c.Alongside {
+		esp, _ := c.Device.Table.ESP()
+		return []Partition{
+			{Type: "efi", Filesystem: "vfat", Mountpoint: "/efi", Number: esp.Number, Existing: true},
+			{Type: "linux", Mountpoint: "/", Number: c.Device.Table.NextNumber()},
+		}
+	}
+	layout := c.Partitions
+	if len(layout) == 0 {
+		layout = []Partition{
+			{Size: c.EFISize, Type: "efi", Filesystem: "vfat", Mountpoint: "/boot"},
+			{Type: "linux", Mountpoint: "/"},
+		}
+	}
+	layout = slices.Clone(layout)
+	for i := range layout {
+		layout[i].Number = i + 1
+	}
+	return layout
+}
+
+// ValidateMountpoints checks that no btrfs subvolume is mounted at or beneath
+// a partition of the layout. Subvolumes are mounted before the partitions, so
+// such a partition would hide the subvolume; give it subvolumes of its own.
+func (c *InstallConfig) ValidateMountpoints() error {
+	for _, sv := range c.BtrfsSubvolumes() {
+		for _, p := range c.PartitionLayout() {
+			switch {
+			case p.Mountpoint == "" || p.IsRoot():
+			case sv.Mountpoint == p.Mountpoint:
+				return fmt.Errorf("subvolume %s and the %s partition are both mounted at %s", sv.Name, p.Name(), sv.Mountpoint)
+			case strings.HasPrefix(sv.Mountpoint, p.Mountpoint+"/"):
+				return fmt.Errorf("subvolume %s at %s is inside the %s partition, which would hide it: leave it out with [[subvolumes]]", sv.Name, sv.Mountpoint, p.Mountpoint)
+			}
+		}
+	}
+	return nil
+}
+
+// PartitionPath returns the device of partition n, counting from 1.
+func (c *InstallConfig) PartitionPath(n int) string {
+	return c.PartitionPrefix() + strconv.Itoa(n)
+}
+
+// findPartition returns the device of the first partition in the layout for
+// which match holds, and the partition itself.
+func (c *InstallConfig) findPartition(match func(Partition) bool) (string, Partition) {
+	for _, p := range c.PartitionLayout() {
+		if match(p) {
+			return c.PartitionPath(p.Number), p
+		}
+	}
+	return "", Partition{}
+}
+
+// EFIMountpoint returns where the installed system mounts the EFI system
+// partition.
+func (c *InstallConfig) EFIMountpoint() string {
+	_, p := c.findPartition(Partition.IsEFI)
+	return p.Mountpoint
+}
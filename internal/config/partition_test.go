@@ -0,0 +1,117 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidatePartitions(t *testing.T) {
+	efi := Partition{Size: "512M", Type: "efi", Mountpoint: "/boot"}
+	root := Partition{Type: "linux", Mountpoint: "/"}
+	valid := [][]Partition{
+		{efi, root},
+		{
+			{Size: "1G", Type: "xbootldr", Filesystem: "ext4", Mountpoint: "/boot"},
+			{Size: "512M", Type: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", Filesystem: "vfat", Mountpoint: "/efi"},
+			{Size: "8G", Type: "swap", Filesystem: "swap", Label: "swap"},
+			{Size: "50G", Type: "root", Filesystem: "btrfs", Mountpoint: "/"},
+			{Type: "8300", Filesystem: "xfs", Mountpoint: "/data"},
+		},
+		{efi, {Size: "40G", Type: "linux", Mountpoint: "/"}, {Size: "10G", Type: "8300"}},
+	}
+	for _, v := range valid {
+		if err := ValidatePartitions(v); err != nil {
+			t.Errorf("ValidatePartitions(%v) = %v, want nil", v, err)
+		}
+	}
+
+	invalid := []struct {
+		parts []Partition
+		want  string
+	}{
+		{[]Partition{root, efi}, "only the last partition"},
+		{[]Partition{{Size: "1T", Type: "efi", Mountpoint: "/boot"}, root}, "invalid partition size"},
+		{[]Partition{efi, {Type: "ntfs", Mountpoint: "/"}}, "invalid type"},
+		{[]Partition{efi, {Type: "linux", Label: "my root", Mountpoint: "/"}}, "invalid label"},
+		{[]Partition{efi, {Type: "linux", Filesystem: "zfs", Mountpoint: "/"}}, "invalid filesystem"},
+		{[]Partition{efi, {Size: "1G", Type: "swap", Filesystem: "swap", Mountpoint: "/swap"}, root}, "swap cannot have a mountpoint"},
+		{[]Partition{efi, {Size: "1G", Type: "linux", Mountpoint: "/data/"}, root}, "clean absolute path"},
+		{[]Partition{efi, {Size: "1G", Type: "linux", Filesystem: "ext4", Mountpoint: "/boot"}, root}, "already mounted"},
+		{[]Partition{efi, {Size: "1G", Type: "linux", Mountpoint: "/data"}, root}, "needs a filesystem"},
+		{[]Partition{efi, {Type: "linux", Filesystem: "swap", Mountpoint: "/"}}, "swap cannot have a mountpoint"},
+		{[]Partition{efi, {Type: "linux", Filesystem: "vfat", Mountpoint: "/"}}, "root filesystem cannot be vfat"},
+		{[]Partition{efi}, "0 partitions mounted at /"},
+		{[]Partition{root}, "0 EFI system partitions"},
+		{[]Partition{efi, {Size: "1G", Type: "ef00", Mountpoint: "/efi"}, root}, "2 EFI system partitions"},
+		{[]Partition{{Size: "512M", Type: "efi", Filesystem: "ext4", Mountpoint: "/boot"}, root}, "must be vfat"},
+		{[]Partition{{Size: "512M", Type: "efi"}, root}, "needs a mountpoint"},
+	}
+	for _, tt := range invalid {
+		err := ValidatePartitions(tt.parts)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ValidatePartitions(%v) = %v, want error containing %q", tt.parts, err, tt.want)
+		}
+	}
+}
+
+func TestPartitionLayout(t *testing.T) {
+	cfg := &InstallConfig{Device: BlockDevice{Name: "nvme0n1"}, EFISize: "1G"}
+	layout := cfg.PartitionLayout()
+	if len(layout) != 2 || layout[0].Size != "1G" || !layout[0].IsEFI() || !layout[1].IsRoot() {
+		t.Errorf("default layout = %v, want EFI of EFISize and root", layout)
+	}
+	if got := cfg.EFIPartition(); got != "/dev/nvme0n1p1" {
+		t.Errorf("EFIPartition() = %q, want /dev/nvme0n1p1", got)
+	}
+	if got := cfg.RootPartition(); got != "/dev/nvme0n1p2" {
+		t.Errorf("RootPartition() = %q, want /dev/nvme0n1p2", got)
+	}
+
+	cfg.Partitions = []Partition{
+		{Size: "8G", Type: "swap", Filesystem: "swap"},
+		{Size: "40G", Type: "root", Mountpoint: "/"},
+		{Size: "512M", Type: "efi", Mountpoint: "/efi"},
+	}
+	if got := cfg.EFIPartition(); got != "/dev/nvme0n1p3" {
+		t.Errorf("EFIPartition() = %q, want /dev/nvme0n1p3", got)
+	}
+	if got := cfg.RootPartition(); got != "/dev/nvme0n1p2" {
+		t.Errorf("RootPartition() = %q, want /dev/nvme0n1p2", got)
+	}
+	if got := cfg.EFIMountpoint(); got != "/efi" {
+		t.Errorf("EFIMountpoint() = %q, want /efi", got)
+	}
+	if got := cfg.Partitions[0].Name(); got != "swap" {
+		t.Errorf("Name() = %q, want swap", got)
+	}
+}
+
+func TestValidateMountpoints(t *testing.T) {
+	efi := Partition{Size: "512M", Type: "efi", Mountpoint: "/boot"}
+	root := Partition{Type: "linux", Mountpoint: "/"}
+	tests := []struct {
+		parts   []Partition
+		subvols []Subvolume
+		fs      Filesystem
+		want    string
+	}{
+		{[]Partition{efi, {Size: "8G", Type: "linux", Filesystem: "ext4", Mountpoint: "/srv"}, root}, nil, FilesystemBtrfs, ""},
+		// The default subvolumes are checked too
+		{[]Partition{efi, {Size: "50G", Type: "home", Filesystem: "xfs", Mountpoint: "/home"}, root}, nil, FilesystemBtrfs, "@home and the /home partition are both mounted at /home"},
+		{[]Partition{efi, {Size: "20G", Type: "linux", Filesystem: "ext4", Mountpoint: "/var"}, root}, nil, FilesystemBtrfs, "@var_log at /var/log is inside the /var partition"},
+		{[]Partition{efi, {Size: "20G", Type: "linux", Filesystem: "ext4", Mountpoint: "/var"}, root}, nil, FilesystemExt4, ""},
+		{[]Partition{efi, {Size: "20G", Type: "linux", Filesystem: "ext4", Mountpoint: "/var"}, root}, []Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@home", Mountpoint: "/home"}}, FilesystemBtrfs, ""},
+		// The default layout mounts the EFI partition at /boot
+		{nil, []Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@efi", Mountpoint: "/boot/efi"}}, FilesystemBtrfs, "@efi at /boot/efi is inside the /boot partition"},
+		{[]Partition{{Size: "512M", Type: "efi", Mountpoint: "/efi"}, root}, []Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@boot", Mountpoint: "/boot"}}, FilesystemBtrfs, ""},
+		// A subvolume may hold a partition, which is mounted after it
+		{[]Partition{efi, {Size: "20G", Type: "linux", Filesystem: "ext4", Mountpoint: "/home/media"}, root}, nil, FilesystemBtrfs, ""},
+	}
+	for _, tt := range tests {
+		cfg := &InstallConfig{Device: BlockDevice{Name: "sda"}, EFISize: "512M", Partitions: tt.parts, Subvolumes: tt.subvols, Filesystem: tt.fs}
+		err := cfg.ValidateMountpoints()
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("ValidateMountpoints(%v, %v) = %v, want %q", tt.parts, tt.subvols, err, tt.want)
+		}
+	}
+}
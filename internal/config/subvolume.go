@@ -0,0 +1,224 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	subvolNameRe = regexp.MustCompile(`^[A-Za-z0-9@_.+-]+$`)
+	compressRe   = regexp.MustCompile(`^(zstd(:([1-9]|1[0-5]))?|zlib(:[1-9])?|lzo|none)$`)
+)
+
+// Subvolume is a btrfs subvolume and where the installed system mounts it.
+type Subvolume struct {
+	Name       string `json:"name"`                // e.g. "@home"
+	Mountpoint string `json:"mountpoint"`          // absolute path in the installed system
+	Compress   string `json:"compress,omitempty"`  // replaces the shared compression for this subvolume
+	Options    string `json:"options,omitempty"`   // mount options added to the defaults
+	NoDataCOW  bool   `json:"nodatacow,omitempty"` // disable copy-on-write, for VM images and databases
+}
+
+// DefaultSubvolumes is the layout used when archy.toml has no [[subvolumes]].
+var DefaultSubvolumes = []Subvolume{
+	{Name: "@", Mountpoint: "/"},
+	{Name: "@home", Mountpoint: "/home"},
+	{Name: "@snapshots", Mountpoint: "/snapshots"},
+	{Name: "@var_log", Mountpoint: "/var/log"},
+}
+
+// ValidateSubvolumes checks a complete subvolume layout: names are unique
+// plain names, "@" is mounted at /, and every mountpoint is a distinct
+// absolute path. Mountpoints taken by partitions, such as the EFI partition's,
+// are checked against the partition layout by ValidateMountpoints.
+func ValidateSubvolumes(subvols []Subvolume) error {
+	names := map[string]bool{}
+	mountpoints := map[string]string{}
+	for _, sv := range subvols {
+		if !subvolNameRe.MatchString(sv.Name) || sv.Name == "." || sv.Name == ".." {
+			return fmt.Errorf("invalid subvolume name %q: use letters, digits and @_.+-", sv.Name)
+		}
+		if names[sv.Name] {
+			return fmt.Errorf("subvolume %s is listed twice", sv.Name)
+		}
+		names[sv.Name] = true
+
+		mp := sv.Mountpoint
+		if !path.IsAbs(mp) {
+			return fmt.Errorf("subvolume %s: mountpoint %q must be an absolute path", sv.Name, mp)
+		}
+		if path.Clean(mp) != mp || strings.ContainsAny(mp, " \t") {
+			return fmt.Errorf("subvolume %s: mountpoint %q must be a clean path without spaces", sv.Name, mp)
+		}
+		if other, ok := mountpoints[mp]; ok {
+			return fmt.Errorf("subvolumes %s and %s are both mounted at %s", other, sv.Name, mp)
+		}
+		mountpoints[mp] = sv.Name
+		if (sv.Name == "@") != (mp == "/") {
+			return fmt.Errorf("subvolume %s is mounted at %s: the root subvolume @ must be mounted at /", sv.Name, mp)
+		}
+
+		if sv.Compress != "" && !compressRe.MatchString(sv.Compress) {
+			return fmt.Errorf("subvolume %s: %w", sv.Name, errCompress(sv.Compress))
+		}
+		for _, opt := range strings.Split(sv.Options, ",") {
+			key, _, _ := strings.Cut(opt, "=")
+			if key == "subvol" || key == "subvolid" || strings.ContainsAny(opt, " \t") {
+				return fmt.Errorf("subvolume %s: invalid mount option %q", sv.Name, opt)
+			}
+		}
+	}
+	if !names["@"] {
+		return fmt.Errorf("subvolumes must include @, mounted at /")
+	}
+	return nil
+}
+
+// BtrfsSubvolumes returns the subvolume layout to create, in mount order:
+// parents before the subvolumes mounted beneath them, starting with @.
+// There are none on other filesystems.
+func (c *InstallConfig) BtrfsSubvolumes() []Subvolume {
+	if c.Filesystem != FilesystemBtrfs {
+		return nil
+	}
+	if len(c.Subvolumes) == 0 {
+		return DefaultSubvolumes
+	}
+	subvols := slices.Clone(c.Subvolumes)
+	slices.SortStableFunc(subvols, func(a, b Subvolume) int { return strings.Compare(a.Mountpoint, b.Mountpoint) })
+	return subvols
+}
+
+// MountOptions are the mount options of the root filesystem, which every
+// btrfs subvolume shares. Options left unset follow the disk, as detected by
+// lsblk. Compress and SpaceCache are btrfs only.
+type MountOptions struct {
+	Compress   string `json:"compress,omitempty"`    // algorithm[:level] or "none"; default zstd, zstd:1 on NVMe
+	SSD        *bool  `json:"ssd,omitempty"`         // default: whether the disk is solid state
+	Discard    string `json:"discard,omitempty"`     // "async" (btrfs only), "sync" or "none"; default async on btrfs SSDs
+	SpaceCache string `json:"space_cache,omitempty"` // "v2", or empty for the kernel's default
+}
+
+func errCompress(s string) error {
+	return fmt.Errorf("invalid compress %q: use zstd[:1-15], zlib[:1-9], lzo or none", s)
+}
+
+// ValidateMountOptions checks the shared mount options for a root
+// filesystem of type f.
+func ValidateMountOptions(o MountOptions, f Filesystem) error {
+	if f != FilesystemBtrfs {
+		switch {
+		case o.Compress != "":
+			return fmt.Errorf("compress needs filesystem = \"btrfs\", not %q", f)
+		case o.SpaceCache != "":
+			return fmt.Errorf("space_cache needs filesystem = \"btrfs\", not %q", f)
+		case o.Discard == "async":
+			return fmt.Errorf("invalid discard %q: %s supports \"sync\" or \"none\"", o.Discard, f)
+		}
+	}
+	if o.Compress != "" && !compressRe.MatchString(o.Compress) {
+		return errCompress(o.Compress)
+	}
+	switch o.Discard {
+	case "", "async", "sync", "none":
+	default:
+		return fmt.Errorf("invalid discard %q: must be \"async\", \"sync\" or \"none\"", o.Discard)
+	}
+	switch o.SpaceCache {
+	case "", "v2":
+	default:
+		return fmt.Errorf("invalid space_cache %q: only \"v2\" is supported", o.SpaceCache)
+	}
+	return nil
+}
+
+// SSD reports whether the target is treated as solid state: as configured,
+// or as detected.
+func (c *InstallConfig) SSD() bool {
+	if c.Mount.SSD != nil {
+		return *c.Mount.SSD
+	}
+	return c.Device.SSD
+}
+
+// Discard returns how freed blocks are discarded: "async", "sync" or "none".
+func (c *InstallConfig) Discard() string {
+	switch {
+	case c.Mount.Discard != "":
+		return c.Mount.Discard
+	case c.SSD() && c.Filesystem == FilesystemBtrfs:
+		return "async"
+	}
+	// Other filesystems leave SSDs to the weekly fstrim.timer
+	return "none"
+}
+
+// compress returns the shared compression setting.
+func (c *InstallConfig) compress() string {
+	switch {
+	case c.Mount.Compress != "":
+		return c.Mount.Compress
+	case c.Device.NVMe:
+		// Higher levels cost more CPU time than an NVMe drive saves
+		return "zstd:1"
+	}
+	return "zstd"
+}
+
+// RootMountOptions returns the mount options of the root filesystem.
+func (c *InstallConfig) RootMountOptions() string {
+	if c.Filesystem == FilesystemBtrfs {
+		return c.BtrfsMountOptions()
+	}
+	opts := []string{"noatime"}
+	if c.Discard() == "sync" {
+		opts = append(opts, "discard")
+	}
+	return strings.Join(opts, ",")
+}
+
+// BtrfsMountOptions returns the mount options every subvolume shares.
+func (c *InstallConfig) BtrfsMountOptions() string {
+	return strings.Join(c.mountOptions(c.compress()), ",")
+}
+
+// SubvolumeMountOptions returns the mount options for sv, without its
+// subvol= option.
+func (c *InstallConfig) SubvolumeMountOptions(sv Subvolume) string {
+	compress := c.compress()
+	if sv.Compress != "" {
+		compress = sv.Compress
+	}
+	opts := c.mountOptions(compress)
+	if sv.Options != "" {
+		opts = append(opts, sv.Options)
+	}
+	return strings.Join(opts, ",")
+}
+
+func (c *InstallConfig) mountOptions(compress string) []string {
+	opts := []string{"noatime"}
+	if compress != "none" {
+		opts = append(opts, "compress="+compress)
+	}
+	switch {
+	case c.SSD():
+		opts = append(opts, "ssd")
+	case c.Mount.SSD != nil:
+		opts = append(opts, "nossd")
+	}
+	// The kernel discards asynchronously on SSDs unless told not to
+	switch d := c.Discard(); {
+	case d != "none":
+		opts = append(opts, "discard="+d)
+	case c.SSD():
+		opts = append(opts, "nodiscard")
+	}
+	if c.Mount.SpaceCache != "" {
+		opts = append(opts, "space_cache="+c.Mount.SpaceCache)
+	}
+	return opts
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSubvolumes(t *testing.T) {
+	valid := [][]Subvolume{
+		DefaultSubvolumes,
+		{{Name: "@", Mountpoint: "/"}},
+		{
+			{Name: "@", Mountpoint: "/", Options: "compress=zstd:1"},
+			{Name: "@var", Mountpoint: "/var"},
+			{Name: "@libvirt", Mountpoint: "/var/lib/libvirt/images", NoDataCOW: true},
+			{Name: "@swap", Mountpoint: "/swap", NoDataCOW: true},
+		},
+	}
+	for _, v := range valid {
+		if err := ValidateSubvolumes(v); err != nil {
+			t.Errorf("ValidateSubvolumes(%v) = %v, want nil", v, err)
+		}
+	}
+
+	invalid := []struct {
+		subvols []Subvolume
+		want    string
+	}{
+		{[]Subvolume{{Name: "@home", Mountpoint: "/home"}}, "must include @"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@", Mountpoint: "/home"}}, "listed twice"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@a", Mountpoint: "/data"}, {Name: "@b", Mountpoint: "/data"}}, "both mounted at /data"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@home", Mountpoint: "home"}}, "absolute"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@home", Mountpoint: "/home/"}}, "clean path"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/root"}}, "must be mounted at /"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "@root", Mountpoint: "/"}}, "both mounted at /"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/"}, {Name: "a/b", Mountpoint: "/a"}}, "invalid subvolume name"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/", Options: "subvol=@other"}}, "invalid mount option"},
+		{[]Subvolume{{Name: "@", Mountpoint: "/", Compress: "zstd:22"}}, "invalid compress"},
+	}
+	for _, tt := range invalid {
+		err := ValidateSubvolumes(tt.subvols)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ValidateSubvolumes(%v) = %v, want error containing %q", tt.subvols, err, tt.want)
+		}
+	}
+}
+
+func TestBtrfsSubvolumes(t *testing.T) {
+	cfg := &InstallConfig{}
+	if got := cfg.BtrfsSubvolumes(); len(got) != 4 || got[0].Name != "@" || got[3].Mountpoint != "/var/log" {
+		t.Errorf("default layout = %v, want DefaultSubvolumes", got)
+	}
+
+	cfg.Subvolumes = []Subvolume{
+		{Name: "@docker", Mountpoint: "/var/lib/docker"},
+		{Name: "@var", Mountpoint: "/var"},
+		{Name: "@", Mountpoint: "/"},
+	}
+	var order []string
+	for _, sv := range cfg.BtrfsSubvolumes() {
+		order = append(order, sv.Name)
+	}
+	if got := strings.Join(order, " "); got != "@ @var @docker" {
+		t.Errorf("mount order = %s, want @ @var @docker", got)
+	}
+	if cfg.Subvolumes[0].Name != "@docker" {
+		t.Error("BtrfsSubvolumes reordered the configuration")
+	}
+}
+
+func TestValidateMountOptions(t *testing.T) {
+	valid := []MountOptions{
+		{},
+		{Compress: "zstd:15", Discard: "async", SpaceCache: "v2"},
+		{Compress: "lzo", Discard: "none"},
+		{Compress: "zlib:9", Discard: "sync"},
+		{Compress: "none"},
+	}
+	for _, o := range valid {
+		if err := ValidateMountOptions(o, FilesystemBtrfs); err != nil {
+			t.Errorf("ValidateMountOptions(%+v) = %v, want nil", o, err)
+		}
+	}
+	invalid := []MountOptions{
+		{Compress: "zstd:0"},
+		{Compress: "zstd:16"},
+		{Compress: "lzo:1"},
+		{Compress: "gzip"},
+		{Discard: "on"},
+		{SpaceCache: "v1"},
+	}
+	for _, o := range invalid {
+		if err := ValidateMountOptions(o, FilesystemBtrfs); err == nil {
+			t.Errorf("ValidateMountOptions(%+v) = nil, want error", o)
+		}
+	}
+
+	yes := true
+	for _, o := range []MountOptions{{}, {SSD: &yes, Discard: "sync"}, {Discard: "none"}} {
+		if err := ValidateMountOptions(o, FilesystemExt4); err != nil {
+			t.Errorf("ValidateMountOptions(%+v, ext4) = %v, want nil", o, err)
+		}
+	}
+	for _, o := range []MountOptions{{Compress: "zstd"}, {SpaceCache: "v2"}, {Discard: "async"}} {
+		if err := ValidateMountOptions(o, FilesystemXFS); err == nil {
+			t.Errorf("ValidateMountOptions(%+v, xfs) = nil, want error", o)
+		}
+	}
+}
+
+func TestBtrfsMountOptions(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		device BlockDevice
+		mount  MountOptions
+		want   string
+	}{
+		{"hdd", BlockDevice{}, MountOptions{}, "noatime,compress=zstd"},
+		{"ssd", BlockDevice{SSD: true}, MountOptions{}, "noatime,compress=zstd,ssd,discard=async"},
+		{"nvme", BlockDevice{SSD: true, NVMe: true}, MountOptions{}, "noatime,compress=zstd:1,ssd,discard=async"},
+		{"ssd without discard", BlockDevice{SSD: true}, MountOptions{Discard: "none"}, "noatime,compress=zstd,ssd,nodiscard"},
+		{"ssd forced off", BlockDevice{SSD: true}, MountOptions{SSD: &no}, "noatime,compress=zstd,nossd"},
+		{"ssd forced on", BlockDevice{}, MountOptions{SSD: &yes, Discard: "sync"}, "noatime,compress=zstd,ssd,discard=sync"},
+		{"explicit", BlockDevice{NVMe: true}, MountOptions{Compress: "none", SpaceCache: "v2"}, "noatime,space_cache=v2"},
+	}
+	for _, tt := range tests {
+		cfg := &InstallConfig{Device: tt.device, Mount: tt.mount}
+		if got := cfg.BtrfsMountOptions(); got != tt.want {
+			t.Errorf("%s: BtrfsMountOptions() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubvolumeMountOptions(t *testing.T) {
+	cfg := &InstallConfig{Device: BlockDevice{SSD: true}}
+	sv := Subvolume{Name: "@vm", Mountpoint: "/vm", Compress: "none", Options: "autodefrag"}
+	if got, want := cfg.SubvolumeMountOptions(sv), "noatime,ssd,discard=async,autodefrag"; got != want {
+		t.Errorf("SubvolumeMountOptions() = %q, want %q", got, want)
+	}
+	sv.Compress = "zstd:9"
+	if got, want := cfg.SubvolumeMountOptions(sv), "noatime,compress=zstd:9,ssd,discard=async,autodefrag"; got != want {
+		t.Errorf("SubvolumeMountOptions() = %q, want %q", got, want)
+	}
+}
+
+func TestRootMountOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  InstallConfig
+		want string
+	}{
+		{"btrfs", InstallConfig{Device: BlockDevice{SSD: true}}, "noatime,compress=zstd,ssd,discard=async"},
+		{"ext4 ssd", InstallConfig{Filesystem: FilesystemExt4, Device: BlockDevice{SSD: true}}, "noatime"},
+		{"xfs sync discard", InstallConfig{Filesystem: FilesystemXFS, Mount: MountOptions{Discard: "sync"}}, "noatime,discard"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.RootMountOptions(); got != tt.want {
+			t.Errorf("%s: RootMountOptions() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	ext4 := &InstallConfig{Filesystem: FilesystemExt4, Subvolumes: DefaultSubvolumes}
+	if got := ext4.BtrfsSubvolumes(); got != nil {
+		t.Errorf("BtrfsSubvolumes() on ext4 = %v, want nil", got)
+	}
+}
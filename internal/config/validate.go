@@ -2,16 +2,18 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 )
 
 var (
-	hostnameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{0,62}$`)
-	usernameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
-	partSizeRe = regexp.MustCompile(`^[0-9]+[MmGg]$`)
-	zramSizeRe = regexp.MustCompile(`^[0-9]+[MmGg]$`)
+	hostnameRe  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]{0,62}$`)
+	usernameRe  = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	partSizeRe  = regexp.MustCompile(`^[0-9]+[MmGg]$`)
+	zramSizeRe  = regexp.MustCompile(`^[0-9]+[MmGg]$`)
 	zramExprRe  = regexp.MustCompile(`^ram\s*/\s*[0-9]+$`)
+	countryRe   = regexp.MustCompile(`^[A-Za-z][A-Za-z .'-]*$`)
 	sshPubKeyRe = regexp.MustCompile(`^(ssh-rsa|ssh-ed25519|ecdsa-sha2-nistp\d+|ssh-dss|sk-ssh-ed25519@openssh\.com|sk-ecdsa-sha2-nistp256@openssh\.com)\s+[A-Za-z0-9+/=]+(\s+\S.*)?$`)
 )
 
@@ -97,3 +99,30 @@ func ValidateSSHPubKey(s string) error {
 	}
 	return nil
 }
+
+// ValidateMirror checks that a mirror is an http, https, ftp or file URL.
+func ValidateMirror(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid mirror %q: %w", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "ftp":
+		if u.Host == "" {
+			return fmt.Errorf("invalid mirror %q: missing host", s)
+		}
+	case "file":
+	default:
+		return fmt.Errorf("invalid mirror %q: must be an http, https, ftp or file URL", s)
+	}
+	return nil
+}
+
+// ValidateMirrorCountry checks that a country name or code can be passed to
+// reflector.
+func ValidateMirrorCountry(s string) error {
+	if !countryRe.MatchString(s) {
+		return fmt.Errorf("invalid mirror country %q: must be a country name or code, like \"Germany\" or \"DE\"", s)
+	}
+	return nil
+}
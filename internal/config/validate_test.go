@@ -101,3 +101,33 @@ func TestValidateZRAMSize(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateMirror(t *testing.T) {
+	valid := []string{"https://geo.mirror.pkgbuild.com/$repo/os/$arch", "http://10.0.0.5/archlinux", "file:///srv/mirror/$repo/os/$arch"}
+	for _, v := range valid {
+		if err := ValidateMirror(v); err != nil {
+			t.Errorf("ValidateMirror(%q) = %v, want nil", v, err)
+		}
+	}
+	invalid := []string{"", "mirror.example.com", "https:///archlinux", "rsync://mirror.example.com/archlinux"}
+	for _, v := range invalid {
+		if err := ValidateMirror(v); err == nil {
+			t.Errorf("ValidateMirror(%q) = nil, want error", v)
+		}
+	}
+}
+
+func TestValidateMirrorCountry(t *testing.T) {
+	valid := []string{"DE", "United States", "Czechia"}
+	for _, v := range valid {
+		if err := ValidateMirrorCountry(v); err != nil {
+			t.Errorf("ValidateMirrorCountry(%q) = %v, want nil", v, err)
+		}
+	}
+	invalid := []string{"", "US,DE", "--verbose", "DE; rm -rf /"}
+	for _, v := range invalid {
+		if err := ValidateMirrorCountry(v); err == nil {
+			t.Errorf("ValidateMirrorCountry(%q) = nil, want error", v)
+		}
+	}
+}
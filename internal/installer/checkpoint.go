@@ -0,0 +1,144 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+const CheckpointPath = "/root/archy.checkpoint.json"
+
+// MountPoint is a filesystem mounted under /mnt during installation.
+type MountPoint struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Options string `json:"options,omitempty"`
+}
+
+// Checkpoint records the phases an install has completed and the disk state
+// needed to pick up where it left off.
+type Checkpoint struct {
+	Device    string       `json:"device"`
+	Mapper    string       `json:"mapper,omitempty"` // LUKS mapper name, empty when unencrypted
+	Mounts    []MountPoint `json:"mounts,omitempty"` // in mount order
+	Completed []string     `json:"completed"`        // Phase.Name of each completed phase
+
+	// Alongside other systems, the disk's partition table before the install
+	// and the root partition created in its free space. The layout is
+	// rebuilt from these on resume, as the disk now holds the root partition.
+	Table *config.PartitionTable `json:"table,omitempty"`
+	Root  string                 `json:"root,omitempty"`
+}
+
+// LoadCheckpoint reads a checkpoint file written by a previous install.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no checkpoint at %s: nothing to resume", path)
+		}
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// Apply fixes the settings the recorded disk state depends on: encryption
+// and, for an install alongside other systems, the partition table the
+// layout was planned on.
+func (cp *Checkpoint) Apply(cfg *config.InstallConfig) {
+	cfg.Encrypt = cp.Mapper != ""
+	if cp.Table != nil {
+		cfg.Alongside = true
+		cfg.Device.Table = cp.Table
+	}
+}
+
+// Done reports whether phase p was completed.
+func (cp *Checkpoint) Done(p Phase) bool {
+	return p.namedIn(cp.Completed...)
+}
+
+func (cp *Checkpoint) save(path string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// saveCheckpoint persists the checkpoint. Failure to write it only costs the
+// ability to resume, so it is logged rather than failing the install.
+func (inst *Installer) saveCheckpoint() {
+	if inst.cfg.DryRun {
+		return
+	}
+	if err := inst.checkpoint.save(inst.checkpointPath); err != nil {
+		inst.logToFile("WARN  checkpoint: %v", err)
+	}
+}
+
+// restore re-establishes the disk state recorded in the checkpoint: it clears
+// anything left mounted by the failed run, re-opens LUKS, remounts the
+// filesystems and enables the swap partitions so the remaining phases see the
+// same system as before.
+func (inst *Installer) restore(ctx context.Context) error {
+	cp := inst.checkpoint
+	if cp.Device != inst.cfg.Device.Path() {
+		return fmt.Errorf("checkpoint is for %s, not %s", cp.Device, inst.cfg.Device.Path())
+	}
+	if (cp.Mapper != "") != inst.cfg.Encrypt {
+		return fmt.Errorf("checkpoint encryption (%v) does not match configuration", cp.Mapper != "")
+	}
+	if cp.Root != "" && cp.Root != inst.cfg.RootPartition() {
+		return fmt.Errorf("checkpoint root partition is %s, not %s", cp.Root, inst.cfg.RootPartition())
+	}
+
+	inst.log("Cleaning up mounts from the previous run...")
+	if err := inst.resetDisk(ctx); err != nil {
+		return err
+	}
+
+	if inst.cfg.OfflineRepo != nil && cp.Done(PhaseBaseInstall) {
+		return inst.bindOfflineRepo(ctx)
+	}
+	return nil
+}
+
+// resetDisk unmounts the target and closes LUKS, then re-opens LUKS and
+// remounts the filesystems if the checkpoint records them as set up. A disk
+// phase that failed part way, or did not get to run, then starts from the
+// state it expects.
+func (inst *Installer) resetDisk(ctx context.Context) error {
+	cp := inst.checkpoint
+	inst.CleanupMounts()
+
+	if cp.Mapper != "" && cp.Done(PhaseLUKS) {
+		inst.log("Re-opening LUKS device as " + cp.Mapper + "...")
+		if out, err := inst.exec.Run(ctx, Command{
+			Name:  "cryptsetup",
+			Args:  []string{"open", inst.cfg.RootPartition(), cp.Mapper},
+			Stdin: inst.cfg.LUKSPassphrase + "\n",
+		}); err != nil {
+			return fmt.Errorf("cryptsetup open: %w: %s", err, out)
+		}
+	}
+
+	if cp.Done(PhaseFilesystem) {
+		for _, m := range cp.Mounts {
+			if err := inst.mount(ctx, m); err != nil {
+				return err
+			}
+		}
+		if err := inst.swapon(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
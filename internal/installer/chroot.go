@@ -1,38 +1,62 @@
 package installer
 
 import (
+	"context"
 	"fmt"
-	"os/exec"
+	"io/fs"
+	"path/filepath"
 )
 
-// chroot creates a command to run inside the chroot at /mnt.
-func chroot(name string, args ...string) *exec.Cmd {
-	chrootArgs := append([]string{"/mnt", name}, args...)
-	return exec.Command("arch-chroot", chrootArgs...)
+// TargetRoot is where the target system is mounted during installation.
+const TargetRoot = "/mnt"
+
+// ChrootExecutor runs commands inside arch-chroot at Root and resolves file
+// paths relative to Root, delegating the actual work to Exec.
+type ChrootExecutor struct {
+	Root string
+	Exec Executor
 }
 
-// chrootRun runs a command inside arch-chroot and returns combined output.
-func (inst *Installer) chrootRun(name string, args ...string) (string, error) {
-	inst.logToFile("RUN   arch-chroot /mnt %s %v", name, args)
-	cmd := chroot(name, args...)
-	out, err := cmd.CombinedOutput()
-	if len(out) > 0 {
-		inst.logToFile("      %s", string(out))
-	}
+func (c ChrootExecutor) Run(ctx context.Context, cmd Command) ([]byte, error) {
+	return c.Exec.Run(ctx, Command{
+		Name:   "arch-chroot",
+		Args:   append([]string{c.Root, cmd.Name}, cmd.Args...),
+		Stdin:  cmd.Stdin,
+		Output: cmd.Output,
+	})
+}
+
+func (c ChrootExecutor) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return c.Exec.WriteFile(filepath.Join(c.Root, name), data, perm)
+}
+
+func (c ChrootExecutor) MkdirAll(path string, perm fs.FileMode) error {
+	return c.Exec.MkdirAll(filepath.Join(c.Root, path), perm)
+}
+
+// chrootRun runs a command inside arch-chroot, streaming its output to the
+// log, and returns the combined output.
+func (inst *Installer) chrootRun(ctx context.Context, name string, args ...string) (string, error) {
+	inst.logToFile("RUN   arch-chroot %s %s %v", TargetRoot, name, args)
+	out, err := inst.stream(ctx, inst.target, Command{Name: name, Args: args})
 	if err != nil {
 		return string(out), fmt.Errorf("%s: %w: %s", name, err, out)
 	}
 	return string(out), nil
 }
 
-// chrootShell runs a shell command string inside arch-chroot.
-func (inst *Installer) chrootShell(command string) (string, error) {
-	inst.logToFile("RUN   arch-chroot /mnt bash -c %q", command)
-	cmd := chroot("bash", "-c", command)
-	out, err := cmd.CombinedOutput()
-	if len(out) > 0 {
-		inst.logToFile("      %s", string(out))
-	}
+// chrootRunAs runs a command inside arch-chroot as user, with the user's
+// home directory as HOME.
+func (inst *Installer) chrootRunAs(ctx context.Context, user, name string, args ...string) (string, error) {
+	return inst.chrootRun(ctx, "sudo", append([]string{"-u", user, "-H", "--", name}, args...)...)
+}
+
+// chrootShell runs a shell command string inside arch-chroot. The command is
+// interpreted by bash, so it must be a constant: configuration values are
+// passed as separate arguments through chrootRun instead.
+func (inst *Installer) chrootShell(ctx context.Context, command string) (string, error) {
+	inst.logToFile("RUN   arch-chroot %s bash -c %q", TargetRoot, command)
+	out, err := inst.stream(ctx, inst.target, Command{Name: "bash", Args: []string{"-c", command}})
 	if err != nil {
 		return string(out), fmt.Errorf("bash -c %q: %w: %s", command, err, out)
 	}
@@ -0,0 +1,97 @@
+package installer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command describes a single program invocation made by the installer.
+type Command struct {
+	Name   string
+	Args   []string
+	Stdin  string    // fed to the process on standard input; never logged or recorded
+	Output io.Writer // if set, receives stdout and stderr as they are produced
+}
+
+// String renders the command as a shell-quoted command line.
+func (c Command) String() string {
+	words := make([]string, 0, len(c.Args)+1)
+	for _, w := range append([]string{c.Name}, c.Args...) {
+		words = append(words, shellQuote(w))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes s for a POSIX shell if it contains anything other than
+// characters that are always safe unquoted.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Executor performs the side effects of an install: running programs and
+// writing files. Phases go through an Executor instead of calling os/exec or
+// writing files directly, so an install can be redirected (e.g. for a dry run).
+type Executor interface {
+	// Run executes cmd and returns its combined stdout and stderr, copying
+	// them to cmd.Output while it runs. The command is killed if ctx is
+	// cancelled before it exits.
+	Run(ctx context.Context, cmd Command) ([]byte, error)
+	// WriteFile writes data to the named file, creating it if necessary.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// MkdirAll creates a directory along with any necessary parents.
+	MkdirAll(path string, perm fs.FileMode) error
+}
+
+// LocalExecutor runs commands and writes files on the live system.
+type LocalExecutor struct{}
+
+// Run starts the command in its own process group so cancellation also kills
+// the children it spawns (pacstrap → pacman, arch-chroot → the chrooted program).
+func (LocalExecutor) Run(ctx context.Context, c Command) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	if c.Stdin != "" {
+		cmd.Stdin = strings.NewReader(c.Stdin)
+	}
+	var out bytes.Buffer
+	var w io.Writer = &out
+	if c.Output != nil {
+		w = io.MultiWriter(&out, c.Output)
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	return out.Bytes(), err
+}
+
+func (LocalExecutor) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (LocalExecutor) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
@@ -0,0 +1,60 @@
+package installer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalExecutorCancelKillsChildren(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	// The shell waits on a child sleep; killing only the shell would leave
+	// the sleep holding the output pipe open until it exits.
+	_, err := LocalExecutor{}.Run(ctx, Command{Name: "sh", Args: []string{"-c", "sleep 30; echo done"}})
+	if err == nil {
+		t.Fatal("Run succeeded, want error after cancellation")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Run returned after %s, want prompt return on cancel", elapsed)
+	}
+}
+
+func TestLocalExecutorStdin(t *testing.T) {
+	out, err := LocalExecutor{}.Run(context.Background(), Command{Name: "cat", Stdin: "secret\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "secret\n" {
+		t.Errorf("output = %q, want stdin echoed", out)
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	c := Command{Name: "bash", Args: []string{"-c", "echo 'hi' > /tmp/x", ""}}
+	want := `bash -c 'echo '\''hi'\'' > /tmp/x' ''`
+	if got := c.String(); got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+func TestLocalExecutorStreamsOutput(t *testing.T) {
+	var lines []string
+	w := newLineWriter(func(line string) { lines = append(lines, line) })
+	out, err := LocalExecutor{}.Run(context.Background(), Command{
+		Name:   "sh",
+		Args:   []string{"-c", "echo one; echo two >&2"},
+		Output: w,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "one\ntwo\n" {
+		t.Errorf("output = %q, want stdout and stderr combined", out)
+	}
+	if len(lines) != 2 {
+		t.Errorf("streamed %q, want two lines", lines)
+	}
+}
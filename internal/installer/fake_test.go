@@ -0,0 +1,168 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+var errFake = errors.New("exit status 1")
+
+// fakeExecutor is an Executor for tests. It records every command and file
+// write, and answers commands from scripted responses.
+type fakeExecutor struct {
+	mu        sync.Mutex
+	responses []fakeResponse
+	cmds      []Command
+	files     map[string]string
+	dirs      []string
+	onRun     func(Command) // called after each command is recorded
+}
+
+type fakeResponse struct {
+	prefix string
+	output string
+	err    error
+}
+
+func newFakeExecutor() *fakeExecutor {
+	return &fakeExecutor{files: map[string]string{}}
+}
+
+// respond scripts the output and error of every command whose command line
+// (as rendered by Command.String) starts with prefix. Later responses take
+// precedence over earlier ones; unscripted commands succeed with no output.
+func (f *fakeExecutor) respond(prefix, output string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses = append(f.responses, fakeResponse{prefix, output, err})
+}
+
+// Run fails without recording the command if ctx is already cancelled, like
+// exec.CommandContext refusing to start.
+func (f *fakeExecutor) Run(ctx context.Context, c Command) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cmds = append(f.cmds, c)
+	if f.onRun != nil {
+		f.onRun(c)
+	}
+	line := c.String()
+	for i := len(f.responses) - 1; i >= 0; i-- {
+		r := f.responses[i]
+		if strings.HasPrefix(line, r.prefix) {
+			if c.Output != nil {
+				io.WriteString(c.Output, r.output)
+			}
+			return []byte(r.output), r.err
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeExecutor) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = string(data)
+	return nil
+}
+
+func (f *fakeExecutor) MkdirAll(path string, perm fs.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dirs = append(f.dirs, path)
+	return nil
+}
+
+// commands returns the command lines run so far.
+func (f *fakeExecutor) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, len(f.cmds))
+	for i, c := range f.cmds {
+		lines[i] = c.String()
+	}
+	return lines
+}
+
+// testConfig returns a complete configuration for a plain SATA install.
+func testConfig() *config.InstallConfig {
+	return &config.InstallConfig{
+		Device:       config.BlockDevice{Name: "sda", Size: "100G"},
+		EFISize:      "512M",
+		Hostname:     "archbox",
+		Timezone:     "America/New_York",
+		Username:     "alice",
+		UserPassword: "userpw",
+		RootPassword: "rootpw",
+		ZRAMSize:     "ram / 2",
+		DockerGroup:  true,
+	}
+}
+
+// newTestInstaller returns an Installer backed by a fake executor. Progress
+// updates are buffered; the install log and checkpoint go to a temp dir.
+func newTestInstaller(t *testing.T, cfg *config.InstallConfig) (*Installer, *fakeExecutor, chan PhaseUpdate) {
+	t.Helper()
+	fake := newFakeExecutor()
+	progress := make(chan PhaseUpdate, 1024)
+	inst := New(cfg, fake, progress)
+	inst.probe = fakeSystem(nil)
+	healthyTarget(fake)
+	dir := t.TempDir()
+	inst.logPath = filepath.Join(dir, "archy.log")
+	inst.checkpointPath = filepath.Join(dir, "archy.checkpoint.json")
+	inst.reportPath = filepath.Join(dir, "archy-report.json")
+	return inst, fake, progress
+}
+
+// targetFstab is what genfstab writes for the default btrfs layout.
+const targetFstab = `# /dev/sda2
+UUID=f00d	/         	btrfs     	rw,noatime,compress=zstd:3,subvol=/@	0 0
+UUID=f00d	/home     	btrfs     	rw,noatime,compress=zstd:3,subvol=/@home	0 0
+UUID=f00d	/snapshots	btrfs     	rw,noatime,compress=zstd:3,subvol=/@snapshots	0 0
+UUID=f00d	/var/log  	btrfs     	rw,noatime,compress=zstd:3,subvol=/@var_log	0 0
+UUID=BEEF	/boot     	vfat      	rw,relatime	0 2
+`
+
+// healthyTarget scripts the reads of the post-install verification so that a
+// default install passes it.
+func healthyTarget(f *fakeExecutor) {
+	f.respond("blkid", "c0ffee\n", nil)
+	f.respond("arch-chroot /mnt cat /etc/fstab", targetFstab, nil)
+	f.respond("arch-chroot /mnt cat /boot/grub/grub.cfg",
+		"linux /@/boot/vmlinuz-linux root=UUID=f00d cryptdevice=UUID=c0ffee:cryptroot\ninitrd /@/boot/initramfs-linux.img\n", nil)
+	f.respond("arch-chroot /mnt cat /etc/mkinitcpio.conf", "HOOKS=(base udev autodetect block encrypt filesystems fsck)\n", nil)
+	f.respond("arch-chroot /mnt systemctl is-enabled", "enabled\n", nil)
+	f.respond("arch-chroot /mnt getent passwd alice", "alice:x:1000:1000::/home/alice:/bin/bash\n", nil)
+}
+
+// assertCommands checks that every entry in want is a substring of some
+// command, in order, and that no command contains an entry of notWant.
+func assertCommands(t *testing.T, got, want, notWant []string) {
+	t.Helper()
+	i := 0
+	for _, line := range got {
+		if i < len(want) && strings.Contains(line, want[i]) {
+			i++
+		}
+		for _, nw := range notWant {
+			if strings.Contains(line, nw) {
+				t.Errorf("unexpected command %q", line)
+			}
+		}
+	}
+	if i < len(want) {
+		t.Errorf("missing command containing %q (in order); ran:\n  %s", want[i], strings.Join(got, "\n  "))
+	}
+}
@@ -0,0 +1,266 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// partitionFS returns the filesystem partition p of cfg's layout is
+// formatted with: the root filesystem for the root partition, vfat for the
+// EFI system partition, and as configured for the rest.
+func partitionFS(cfg *config.InstallConfig, p config.Partition) string {
+	switch {
+	case p.IsRoot():
+		return cfg.Filesystem.String()
+	case p.IsEFI():
+		return "vfat"
+	}
+	return p.Filesystem
+}
+
+// mkfs returns the command that creates filesystem fs, labelled label if it
+// is not empty, on dev.
+func mkfs(fs, label, dev string) (string, []string) {
+	var name string
+	var args []string
+	switch fs {
+	case "vfat":
+		name, args = "mkfs.fat", []string{"-F32"}
+		if label != "" {
+			args = append(args, "-n", label)
+		}
+		return name, append(args, dev)
+	case "ext4":
+		name, args = "mkfs.ext4", []string{"-F"}
+	case "xfs":
+		name, args = "mkfs.xfs", []string{"-f"}
+	case "swap":
+		name = "mkswap"
+	default:
+		name, args = "mkfs.btrfs", []string{"-f"}
+	}
+	if label != "" {
+		args = append(args, "-L", label)
+	}
+	return name, append(args, dev)
+}
+
+// mkfsTools returns the commands that format the partitions of cfg's layout.
+func mkfsTools(cfg *config.InstallConfig) []string {
+	var tools []string
+	for _, p := range cfg.PartitionLayout() {
+		if fs := partitionFS(cfg, p); fs != "" && !p.Existing {
+			if name, _ := mkfs(fs, "", ""); !slices.Contains(tools, name) {
+				tools = append(tools, name)
+			}
+		}
+	}
+	return tools
+}
+
+// formatRoot creates the configured root filesystem on dev.
+func (inst *Installer) formatRoot(ctx context.Context, dev string) error {
+	fs := inst.cfg.Filesystem
+	inst.log("Formatting " + dev + " as " + fs.String() + "...")
+	name, args := mkfs(fs.String(), "ArchRoot", dev)
+	return inst.run(ctx, name, args...)
+}
+
+// partitionMounts returns the partitions of the layout mounted beneath the
+// root, parents before the partitions mounted inside them.
+func (inst *Installer) partitionMounts() []MountPoint {
+	var mounts []MountPoint
+	for _, p := range inst.cfg.PartitionLayout() {
+		if p.Mountpoint != "" && !p.IsRoot() {
+			mounts = append(mounts, MountPoint{inst.cfg.PartitionPath(p.Number), targetMount(p.Mountpoint), ""})
+		}
+	}
+	slices.SortStableFunc(mounts, func(a, b MountPoint) int { return strings.Compare(a.Target, b.Target) })
+	return mounts
+}
+
+// swapPartitions returns the devices of the swap partitions in the layout.
+func (inst *Installer) swapPartitions() []string {
+	var devs []string
+	for _, p := range inst.cfg.PartitionLayout() {
+		if p.Filesystem == "swap" {
+			devs = append(devs, inst.cfg.PartitionPath(p.Number))
+		}
+	}
+	return devs
+}
+
+// swapon enables the swap partitions of the layout.
+func (inst *Installer) swapon(ctx context.Context) error {
+	for _, dev := range inst.swapPartitions() {
+		inst.log("Enabling swap on " + dev + "...")
+		if err := inst.run(ctx, "swapon", dev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nestedIn returns the targets of the mounts beneath target.
+func nestedIn(target string, mounts []MountPoint) []string {
+	var nested []string
+	for _, m := range mounts {
+		if strings.HasPrefix(m.Target, target+"/") {
+			nested = append(nested, m.Target)
+		}
+	}
+	return nested
+}
+
+// mountFilesystems mounts the root filesystem at /mnt, laid out as the
+// filesystem needs, then the other partitions of the layout beneath it, and
+// generates fstab from the result. Swap partitions are enabled so genfstab
+// lists them.
+func (inst *Installer) mountFilesystems(ctx context.Context) error {
+	parts := inst.partitionMounts()
+	var (
+		mounts []MountPoint
+		err    error
+	)
+	if inst.cfg.Filesystem == config.FilesystemBtrfs {
+		mounts, err = inst.mountBtrfs(ctx, parts)
+	} else {
+		mounts, err = inst.mountRoot(ctx, parts)
+	}
+	if err != nil {
+		return err
+	}
+
+	// A partition mounted over a directory hides the mount points made in
+	// it, so make those again.
+	for i, m := range parts {
+		if err := inst.mount(ctx, m); err != nil {
+			return err
+		}
+		if err := inst.mkdirs(nestedIn(m.Target, parts[i+1:])); err != nil {
+			return err
+		}
+	}
+	if err := inst.swapon(ctx); err != nil {
+		return err
+	}
+	inst.checkpoint.Mounts = append(mounts, parts...)
+
+	inst.log("Generating fstab...")
+	return inst.run(ctx, "bash", "-c", "genfstab -U /mnt > /mnt/etc/fstab")
+}
+
+// mountRoot mounts a filesystem without subvolumes at /mnt and makes the
+// mount points of the partitions in parts.
+func (inst *Installer) mountRoot(ctx context.Context, parts []MountPoint) ([]MountPoint, error) {
+	root := MountPoint{inst.cfg.RootDevice(), TargetRoot, inst.cfg.RootMountOptions()}
+	if err := inst.mount(ctx, root); err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, m := range parts {
+		dirs = append(dirs, m.Target)
+	}
+	if err := inst.mkdirs(append(dirs, "/mnt/etc")); err != nil {
+		return nil, err
+	}
+	return []MountPoint{root}, nil
+}
+
+// mountBtrfs creates the subvolumes and mounts each at its place under /mnt,
+// making the mount points of the partitions in parts on the way.
+func (inst *Installer) mountBtrfs(ctx context.Context, parts []MountPoint) ([]MountPoint, error) {
+	btrfsDev := inst.cfg.RootDevice()
+
+	inst.log("Mounting btrfs root...")
+	if err := inst.run(ctx, "mount", btrfsDev, "/mnt"); err != nil {
+		return nil, err
+	}
+
+	// A retried or resumed phase finds the subvolumes it made before
+	existing, err := inst.subvolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	subvolumes := inst.cfg.BtrfsSubvolumes()
+	for _, sv := range subvolumes {
+		if slices.Contains(existing, sv.Name) {
+			inst.log("Keeping existing subvolume " + sv.Name)
+			continue
+		}
+		inst.log("Creating subvolume " + sv.Name + "...")
+		if err := inst.run(ctx, "btrfs", "subvolume", "create", "/mnt/"+sv.Name); err != nil {
+			return nil, err
+		}
+		// +C only takes effect on files created afterwards, so set it
+		// while the subvolume is still empty
+		if sv.NoDataCOW {
+			inst.log("Disabling copy-on-write for " + sv.Name + "...")
+			if err := inst.run(ctx, "chattr", "+C", "/mnt/"+sv.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	inst.log("Unmounting to remount with subvolumes...")
+	if err := inst.run(ctx, "umount", "/mnt"); err != nil {
+		return nil, err
+	}
+
+	var mounts []MountPoint
+	for _, sv := range subvolumes {
+		opts := inst.cfg.SubvolumeMountOptions(sv) + ",subvol=" + sv.Name
+		mounts = append(mounts, MountPoint{btrfsDev, targetMount(sv.Mountpoint), opts})
+	}
+
+	// Mount @ subvolume
+	if err := inst.mount(ctx, mounts[0]); err != nil {
+		return nil, err
+	}
+
+	// Create mount points
+	var dirs []string
+	for _, m := range parts {
+		dirs = append(dirs, m.Target)
+	}
+	for _, m := range mounts[1:] {
+		dirs = append(dirs, m.Target)
+	}
+	dirs = append(dirs, "/mnt/etc")
+	if err := inst.mkdirs(dirs); err != nil {
+		return nil, err
+	}
+
+	// Mount remaining subvolumes. A subvolume mounted over a directory
+	// hides the mount points made in it, so make those again.
+	for i, m := range mounts[1:] {
+		if err := inst.mount(ctx, m); err != nil {
+			return nil, err
+		}
+		if err := inst.mkdirs(nestedIn(m.Target, slices.Concat(mounts[i+2:], parts))); err != nil {
+			return nil, err
+		}
+	}
+	return mounts, nil
+}
+
+// subvolumes returns the names of the subvolumes in the btrfs filesystem
+// mounted at /mnt.
+func (inst *Installer) subvolumes(ctx context.Context) ([]string, error) {
+	out, err := inst.exec.Run(ctx, Command{Name: "btrfs", Args: []string{"subvolume", "list", "/mnt"}})
+	if err != nil {
+		return nil, fmt.Errorf("btrfs subvolume list: %w: %s", err, out)
+	}
+	// ID 256 gen 9 top level 5 path @home
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if _, name, ok := strings.Cut(line, " path "); ok {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return names, nil
+}
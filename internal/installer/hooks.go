@@ -0,0 +1,102 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// Hook scripts are copied here before they run. arch-chroot mounts a tmpfs
+// over the target's /tmp, so chroot hooks use /var/tmp.
+const (
+	liveHookDir   = "/tmp/archy-hooks"
+	targetHookDir = "/var/tmp/archy-hooks"
+)
+
+// CheckHooks reports the first hook that follows a phase archy does not have,
+// or that would run before what it needs exists: the installed system for a
+// chroot hook, or the user for an as_user hook.
+func CheckHooks(hooks []config.Hook) error {
+	for _, h := range hooks {
+		p, ok := PhaseByName(h.After)
+		switch {
+		case !ok:
+			return fmt.Errorf("hook %q: unknown phase %q", h.Script, h.After)
+		case h.AsUser && p.before(PhaseSystemConfig):
+			return fmt.Errorf("hook %q: as_user hooks need the user created by %s, but run after %s", h.Script, PhaseSystemConfig.Name(), h.After)
+		case h.Chroot && p.before(PhaseBaseInstall):
+			return fmt.Errorf("hook %q: chroot hooks need the system installed by %s, but run after %s", h.Script, PhaseBaseInstall.Name(), h.After)
+		}
+	}
+	return nil
+}
+
+// runHooks runs the hooks that follow phase p, in configuration order. A
+// failing hook fails the phase only when it is marked fatal.
+func (inst *Installer) runHooks(ctx context.Context, p Phase) error {
+	for i, h := range inst.cfg.Hooks {
+		if !p.namedIn(h.After) {
+			continue
+		}
+		if err := inst.runHook(ctx, i, h); err != nil {
+			if h.Fatal || ctx.Err() != nil {
+				return fmt.Errorf("hook %s: %w", h.Script, err)
+			}
+			inst.log("Warning: hook " + h.Script + " failed (not fatal)")
+		}
+	}
+	return nil
+}
+
+// runHook copies the script for hook i into place and runs it, streaming its
+// output to the log. The script is run directly, so it needs a shebang line.
+func (inst *Installer) runHook(ctx context.Context, i int, h config.Hook) error {
+	var (
+		data []byte
+		err  error
+	)
+	if inst.cfg.BundleFS != nil {
+		data, err = fs.ReadFile(inst.cfg.BundleFS, h.Script)
+	} else {
+		data, err = os.ReadFile(h.Script)
+	}
+	if err != nil {
+		return fmt.Errorf("read hook: %w", err)
+	}
+
+	name := fmt.Sprintf("%02d-%s", i, path.Base(h.Script))
+	if !h.Chroot {
+		inst.log("Running hook " + h.Script + "...")
+		script := path.Join(liveHookDir, name)
+		if err := inst.exec.MkdirAll(liveHookDir, 0o700); err != nil {
+			return err
+		}
+		if err := inst.exec.WriteFile(script, data, 0o700); err != nil {
+			return err
+		}
+		err = inst.run(ctx, script)
+		_ = inst.run(context.Background(), "rm", "-f", script)
+		return err
+	}
+
+	script := path.Join(targetHookDir, name)
+	if err := inst.target.MkdirAll(targetHookDir, 0o755); err != nil {
+		return err
+	}
+	if err := inst.target.WriteFile(script, data, 0o755); err != nil {
+		return err
+	}
+	if h.AsUser {
+		inst.log("Running hook " + h.Script + " in the target as " + inst.cfg.Username + "...")
+		_, err = inst.chrootRunAs(ctx, inst.cfg.Username, script)
+	} else {
+		inst.log("Running hook " + h.Script + " in the target...")
+		_, err = inst.chrootRun(ctx, script)
+	}
+	_, _ = inst.chrootRun(context.Background(), "rm", "-f", script)
+	return err
+}
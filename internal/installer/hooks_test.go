@@ -0,0 +1,107 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+func hookConfig(hooks ...config.Hook) *config.InstallConfig {
+	cfg := testConfig()
+	cfg.BundleFS = fstest.MapFS{
+		"hooks/live.sh":   {Data: []byte("#!/bin/sh\necho live\n")},
+		"hooks/target.sh": {Data: []byte("#!/bin/sh\necho target\n")},
+	}
+	cfg.Hooks = hooks
+	return cfg
+}
+
+func TestRunHooks(t *testing.T) {
+	cfg := hookConfig(
+		config.Hook{After: "base_install", Script: "hooks/live.sh"},
+		config.Hook{After: "base_install", Script: "hooks/target.sh", Chroot: true, AsUser: true},
+		config.Hook{After: "docker", Script: "hooks/live.sh"}, // docker is skipped
+		config.Hook{After: "software", Script: "hooks/target.sh", Chroot: true},
+	)
+	inst, fake, progress := newTestInstaller(t, cfg)
+	fake.respond("/tmp/archy-hooks/00-live.sh", "live\n", nil)
+	inst.Run(context.Background())
+
+	if last := drain(progress); !last[len(last)-1].Done {
+		t.Fatalf("last update = %+v, want Done", last[len(last)-1])
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"pacstrap",
+			"/tmp/archy-hooks/00-live.sh",
+			"rm -f /tmp/archy-hooks/00-live.sh",
+			"arch-chroot /mnt sudo -u alice -H -- /var/tmp/archy-hooks/01-target.sh",
+			"arch-chroot /mnt hwclock",
+			"arch-chroot /mnt /var/tmp/archy-hooks/03-target.sh",
+		},
+		[]string{"02-live.sh"},
+	)
+	if got := fake.files["/tmp/archy-hooks/00-live.sh"]; got != "#!/bin/sh\necho live\n" {
+		t.Errorf("live hook = %q", got)
+	}
+	if _, ok := fake.files["/mnt/var/tmp/archy-hooks/01-target.sh"]; !ok {
+		t.Errorf("chroot hook not written into the target: %v", fake.files)
+	}
+}
+
+func TestRunHookFailure(t *testing.T) {
+	for _, fatal := range []bool{false, true} {
+		cfg := hookConfig(config.Hook{After: "partition", Script: "hooks/live.sh", Fatal: fatal})
+		inst, fake, progress := newTestInstaller(t, cfg)
+		fake.respond("/tmp/archy-hooks/00-live.sh", "", errFake)
+		inst.Run(context.Background())
+
+		last := drain(progress)
+		u := last[len(last)-1]
+		if fatal && (u.Err == nil || u.Phase != PhasePartition) {
+			t.Errorf("fatal hook: last update = %+v, want error in %s", u, PhasePartition)
+		}
+		if !fatal && !u.Done {
+			t.Errorf("non-fatal hook: last update = %+v, want Done", u)
+		}
+	}
+}
+
+func TestCheckHooks(t *testing.T) {
+	valid := []config.Hook{
+		{After: "base_install"},
+		{After: "dotfiles"},
+		{After: "partition"},
+		{After: "base_install", Chroot: true},
+		{After: "btrfs"},
+		{After: "system_config", Chroot: true, AsUser: true},
+		{After: "software", Chroot: true, AsUser: true},
+	}
+	if err := CheckHooks(valid); err != nil {
+		t.Errorf("CheckHooks = %v, want nil", err)
+	}
+
+	invalid := []struct {
+		hook config.Hook
+		want string
+	}{
+		{config.Hook{After: "base-install"}, "unknown phase"},
+		{config.Hook{After: "preflight", Chroot: true}, "chroot hooks need the system installed by base_install"},
+		{config.Hook{After: "partition", Chroot: true}, "chroot hooks"},
+		{config.Hook{After: "luks", Chroot: true}, "chroot hooks"},
+		{config.Hook{After: "filesystem", Chroot: true}, "chroot hooks"},
+		{config.Hook{After: "btrfs", Chroot: true}, "chroot hooks"},
+		{config.Hook{After: "prepare", Chroot: true, AsUser: true}, "as_user hooks need the user created by system_config"},
+		{config.Hook{After: "base_install", Chroot: true, AsUser: true}, "as_user hooks"},
+	}
+	for _, tt := range invalid {
+		tt.hook.Script = "x.sh"
+		err := CheckHooks([]config.Hook{tt.hook})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("CheckHooks(%+v) = %v, want error containing %q", tt.hook, err, tt.want)
+		}
+	}
+}
@@ -1,10 +1,9 @@
 package installer
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
 	"time"
 
 	"github.com/tallenh/archy/internal/config"
@@ -15,76 +14,189 @@ const LogPath = "/root/archy.log"
 // Installer orchestrates the installation process.
 type Installer struct {
 	cfg      *config.InstallConfig
+	exec     Executor // live environment
+	target   Executor // installed system, via arch-chroot
 	progress chan<- PhaseUpdate
+	logPath  string
 	logFile  *os.File
+	secrets  redactor
+	probe    probe // live system state for the pre-flight checks
+
+	checkpoint     *Checkpoint
+	checkpointPath string
+
+	report     *Report
+	reportPath string
+	virt       string    // systemd-detect-virt output, once known
+	mirrors    string    // generated mirror list, once known
+	packages   []string  // package set for the install, once known
+	prefetch   *prefetch // background package download, once started
+
+	decisions <-chan Decision // set by AwaitDecisions
+
+	// Progress accounting for the running phase, as fractions of the install
+	phase      Phase
+	phaseStart float64
+	phaseShare float64
 }
 
-// New creates an Installer that reports progress to the given channel.
-func New(cfg *config.InstallConfig, progress chan<- PhaseUpdate) *Installer {
-	return &Installer{cfg: cfg, progress: progress}
+// New creates an Installer that performs all side effects through exec and
+// reports progress to the given channel.
+func New(cfg *config.InstallConfig, exec Executor, progress chan<- PhaseUpdate) *Installer {
+	cp := &Checkpoint{Device: cfg.Device.Path()}
+	if cfg.Encrypt {
+		cp.Mapper = "cryptroot"
+	}
+	if cfg.Alongside {
+		cp.Table, cp.Root = cfg.Device.Table, cfg.RootPartition()
+	}
+	inst := &Installer{
+		cfg:            cfg,
+		exec:           exec,
+		target:         ChrootExecutor{Root: TargetRoot, Exec: exec},
+		progress:       progress,
+		logPath:        LogPath,
+		probe:          liveSystem,
+		checkpoint:     cp,
+		checkpointPath: CheckpointPath,
+		report:         newReport(cfg),
+		reportPath:     ReportPath,
+	}
+	inst.secrets.add(cfg.RootPassword)
+	inst.secrets.add(cfg.UserPassword)
+	inst.secrets.add(cfg.LUKSPassphrase)
+	return inst
 }
 
-// Run executes all installation phases in order.
-func (inst *Installer) Run() {
+// Run executes all installation phases in order. When resuming, phases
+// recorded in the checkpoint are skipped after restoring their disk state.
+// Cancelling ctx kills the running command, unmounts the target and closes
+// LUKS before Run returns. A failed phase stops the install, or with
+// AwaitDecisions waits to be retried, skipped or aborted.
+func (inst *Installer) Run(ctx context.Context) {
 	inst.openLog()
 	defer inst.closeLog()
 
-	phases := []struct {
-		phase Phase
-		fn    func() error
-		skip  bool
-	}{
-		{PhasePrepare, inst.prepare, false},
-		{PhasePartition, inst.partition, false},
-		{PhaseLUKS, inst.setupLUKS, !inst.cfg.Encrypt},
-		{PhaseBtrfs, inst.configureBtrfs, false},
-		{PhaseBaseInstall, inst.installBase, false},
-		{PhaseSystemConfig, inst.configureSystem, false},
-		{PhaseSwap, inst.configureSwap, false},
-		{PhaseBootloader, inst.installBootloader, false},
-		{PhaseServices, inst.enableServices, false},
-		{PhaseSSHD, inst.configureSSHD, !inst.cfg.SSHD},
-		{PhaseDocker, inst.installDocker, !inst.cfg.Docker},
-		{PhaseDesktop, inst.installDesktop, inst.cfg.Desktop == config.DesktopNone},
-		{PhaseSoftware, inst.installSoftware, false},
-		{PhaseDotfiles, inst.installDotfiles, len(inst.cfg.Dotfiles) == 0},
-	}
-
-	total := 0
+	if inst.cfg.Resume {
+		cp, err := LoadCheckpoint(inst.checkpointPath)
+		if err == nil {
+			inst.checkpoint = cp
+			err = inst.restore(ctx)
+		}
+		if ctx.Err() != nil {
+			inst.abort(PhasePreflight, 0)
+			return
+		}
+		if err != nil {
+			inst.logToFile("FAIL  resume: %v", err)
+			inst.writeReport(ctx, "failed", err)
+			inst.progress <- PhaseUpdate{Description: "Resuming installation", Err: inst.secrets.redactErr(err)}
+			return
+		}
+	}
+
+	phases, err := inst.plan()
+	if err != nil {
+		inst.logToFile("FAIL  plan: %v", err)
+		inst.writeReport(ctx, "failed", err)
+		inst.progress <- PhaseUpdate{Description: "Planning installation", Err: err}
+		return
+	}
+
+	total := 0.0
 	for _, p := range phases {
-		if !p.skip {
-			total++
+		if p.state == stateRun {
+			total += p.Weight
 		}
 	}
 
-	completed := 0
+	done := 0.0
 	for _, p := range phases {
-		if p.skip {
-			inst.logToFile("SKIP  %s", p.phase)
+		switch p.state {
+		case stateSkipped, stateUnselected:
+			inst.logToFile("SKIP  %s (%s)", p.Phase, p.state)
+			inst.report.phaseDone(p.Phase, string(p.state))
+			continue
+		case stateCheckpoint:
+			inst.logToFile("DONE  %s (checkpoint)", p.Phase)
+			inst.report.phaseDone(p.Phase, string(p.state))
 			continue
 		}
-		inst.logToFile("START %s", p.phase)
+		inst.phase = p.Phase
+		inst.phaseStart = done / total
+		inst.phaseShare = p.Weight / total
+		if ctx.Err() != nil {
+			inst.abort(p.Phase, inst.phaseStart)
+			return
+		}
+		inst.logToFile("START %s", p.Phase)
+		started := time.Now()
 		inst.progress <- PhaseUpdate{
-			Phase:       p.phase,
-			Description: p.phase.String(),
-			Percent:     float64(completed) / float64(total),
+			Phase:       p.Phase,
+			Description: p.Description,
+			Percent:     inst.phaseStart,
+		}
+		err := inst.runPhase(ctx, p)
+		decision := DecisionAbort
+		for err != nil && ctx.Err() == nil {
+			inst.logToFile("FAIL  %s: %v", p.Phase, err)
+			decision = inst.decide(ctx, p, err)
+			if decision != DecisionRetry {
+				break
+			}
+			inst.log("Retrying: " + p.Description)
+			inst.progress <- PhaseUpdate{
+				Phase:       p.Phase,
+				Description: p.Description,
+				Percent:     inst.phaseStart,
+			}
+			started = time.Now()
+			err = inst.retryPhase(ctx, p)
 		}
-		if err := p.fn(); err != nil {
-			inst.logToFile("FAIL  %s: %v", p.phase, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				inst.report.phaseRan(p.Phase, "cancelled", started)
+				inst.abort(p.Phase, inst.phaseStart)
+				return
+			}
+			if decision == DecisionSkip {
+				inst.log("Skipping " + p.Phase.Name() + " after failure")
+				inst.report.phaseRan(p.Phase, "skipped after failure", started)
+				done += p.Weight
+				continue
+			}
+			inst.report.phaseRan(p.Phase, "failed", started)
+			inst.stopPrefetch()
+			if inst.decisions != nil {
+				inst.log("Cleaning up mounts...")
+				inst.CleanupMounts()
+			}
+			inst.writeReport(ctx, "failed", err)
 			inst.progress <- PhaseUpdate{
-				Phase:       p.phase,
-				Description: p.phase.String(),
-				Percent:     float64(completed) / float64(total),
-				Err:         err,
+				Phase:       p.Phase,
+				Description: p.Description,
+				Percent:     inst.phaseStart,
+				Err:         inst.secrets.redactErr(err),
 			}
 			return
 		}
-		inst.logToFile("OK    %s", p.phase)
-		completed++
+		inst.logToFile("OK    %s", p.Phase)
+		inst.report.phaseRan(p.Phase, "ok", started)
+		if !inst.checkpoint.Done(p.Phase) {
+			inst.checkpoint.Completed = append(inst.checkpoint.Completed, p.Phase.Name())
+		}
+		inst.saveCheckpoint()
+		done += p.Weight
 	}
 
-	// Copy log to installed system
-	inst.copyLogToTarget()
+	// A finished install has nothing left to resume; a partial one may
+	if !inst.cfg.DryRun && len(inst.cfg.OnlyPhases) == 0 {
+		os.Remove(inst.checkpointPath)
+	}
+
+	// Copy log and report to installed system
+	inst.writeReport(ctx, "complete", nil)
+	inst.copyToTarget(ctx)
 
 	inst.progress <- PhaseUpdate{
 		Description: "Installation complete",
@@ -93,28 +205,91 @@ func (inst *Installer) Run() {
 	}
 }
 
-// run executes a command and sends its output as a log line.
-func (inst *Installer) run(name string, args ...string) error {
-	inst.logToFile("RUN   %s %v", name, args)
-	cmd := exec.Command(name, args...)
-	out, err := cmd.CombinedOutput()
-	if len(out) > 0 {
-		inst.log(string(out))
+// abort cleans up after a cancelled install and reports the cancellation.
+func (inst *Installer) abort(phase Phase, percent float64) {
+	inst.logToFile("ABORT %s", phase)
+	inst.stopPrefetch()
+	inst.log("Installation cancelled, cleaning up mounts...")
+	inst.CleanupMounts()
+	inst.writeReport(context.Background(), "cancelled", context.Canceled)
+	inst.progress <- PhaseUpdate{
+		Phase:       phase,
+		Description: "Installation cancelled",
+		Percent:     percent,
+		Err:         context.Canceled,
 	}
+}
+
+// runPhase runs p and then the hooks that follow it.
+func (inst *Installer) runPhase(ctx context.Context, p plannedPhase) error {
+	if err := p.run(inst, ctx); err != nil {
+		return err
+	}
+	return inst.runHooks(ctx, p.Phase)
+}
+
+// retryPhase runs p again after it failed. A disk phase may have left
+// filesystems mounted or LUKS open, so those are reset first.
+func (inst *Installer) retryPhase(ctx context.Context, p plannedPhase) error {
+	if p.Disk {
+		inst.log("Cleaning up mounts from the failed attempt...")
+		if err := inst.resetDisk(ctx); err != nil {
+			return err
+		}
+	}
+	return inst.runPhase(ctx, p)
+}
+
+// run executes a command, streaming its output as log lines.
+func (inst *Installer) run(ctx context.Context, name string, args ...string) error {
+	inst.logToFile("RUN   %s %v", name, args)
+	out, err := inst.stream(ctx, inst.exec, Command{Name: name, Args: args})
 	if err != nil {
 		return fmt.Errorf("%s: %w: %s", name, err, out)
 	}
 	return nil
 }
 
+// stream runs cmd on e, sending each line of output to the log as it is
+// produced. It returns the complete output once the command exits.
+// Package transactions also report how far through the phase they are.
+func (inst *Installer) stream(ctx context.Context, e Executor, cmd Command) ([]byte, error) {
+	onLine := inst.log
+	if isPacman(cmd) {
+		var p pacmanProgress
+		onLine = func(line string) {
+			inst.log(line)
+			if p.update(line) {
+				inst.reportPhaseProgress(p.fraction())
+			}
+		}
+	}
+	w := newLineWriter(onLine)
+	cmd.Output = w
+	out, err := e.Run(ctx, cmd)
+	w.Flush()
+	return out, err
+}
+
+// reportPhaseProgress reports that the running phase is frac complete.
+func (inst *Installer) reportPhaseProgress(frac float64) {
+	inst.progress <- PhaseUpdate{
+		Phase:       inst.phase,
+		Description: inst.phase.String(),
+		Percent:     inst.phaseStart + frac*inst.phaseShare,
+	}
+}
+
 // log sends a log line to the progress channel and writes it to the log file.
+// Registered secrets are masked in both.
 func (inst *Installer) log(line string) {
+	line = inst.secrets.redact(line)
 	inst.logToFile("      %s", line)
 	inst.progress <- PhaseUpdate{LogLine: line}
 }
 
 func (inst *Installer) openLog() {
-	f, err := os.OpenFile(LogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	f, err := os.OpenFile(inst.logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
 		return
 	}
@@ -134,29 +309,13 @@ func (inst *Installer) logToFile(format string, a ...any) {
 		return
 	}
 	ts := time.Now().Format("15:04:05")
-	fmt.Fprintf(inst.logFile, "%s %s\n", ts, fmt.Sprintf(format, a...))
+	fmt.Fprintf(inst.logFile, "%s %s\n", ts, inst.secrets.redact(fmt.Sprintf(format, a...)))
 }
 
-func (inst *Installer) copyLogToTarget() {
-	if inst.logFile == nil {
-		return
-	}
-	inst.logFile.Close()
-
-	src, err := os.Open(LogPath)
-	if err != nil {
-		return
-	}
-	defer src.Close()
-
-	dst, err := os.OpenFile("/mnt/root/archy.log", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
-	if err != nil {
-		return
+// copyToTarget copies the install log and report into the installed system.
+func (inst *Installer) copyToTarget(ctx context.Context) {
+	if inst.logFile != nil {
+		_, _ = inst.exec.Run(ctx, Command{Name: "cp", Args: []string{inst.logPath, TargetRoot + LogPath}})
 	}
-	defer dst.Close()
-
-	io.Copy(dst, src)
-
-	// Reopen for any further writes
-	inst.logFile, _ = os.OpenFile(LogPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	_, _ = inst.exec.Run(ctx, Command{Name: "cp", Args: []string{inst.reportPath, TargetRoot + ReportPath}})
 }
@@ -0,0 +1,272 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// drain collects every update sent so far.
+func drain(ch chan PhaseUpdate) []PhaseUpdate {
+	var updates []PhaseUpdate
+	for {
+		select {
+		case u := <-ch:
+			updates = append(updates, u)
+		default:
+			return updates
+		}
+	}
+}
+
+func TestRunCompletes(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	last := updates[len(updates)-1]
+	if !last.Done || last.Err != nil || last.Percent != 1.0 {
+		t.Errorf("last update = %+v, want Done at 100%%", last)
+	}
+	// Optional phases are skipped with the default configuration
+	assertCommands(t, fake.commands(),
+		[]string{"sgdisk --zap-all", "pacstrap /mnt --cachedir", "grub-install"},
+		[]string{"cryptsetup", "openssh", "docker"},
+	)
+}
+
+func TestRunStopsAtFailedPhase(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacstrap", "error: failed retrieving file", errFake)
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	last := updates[len(updates)-1]
+	if last.Err == nil || last.Phase != PhaseBaseInstall {
+		t.Errorf("last update = %+v, want error in %s", last, PhaseBaseInstall)
+	}
+	if last.Done {
+		t.Error("failed install reported Done")
+	}
+	assertCommands(t, fake.commands(), []string{"pacstrap"}, []string{"arch-chroot"})
+}
+
+func TestChrootExecutor(t *testing.T) {
+	fake := newFakeExecutor()
+	c := ChrootExecutor{Root: "/mnt", Exec: fake}
+
+	if _, err := c.Run(context.Background(), Command{Name: "chpasswd", Stdin: "root:pw\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.cmds[0]; got.String() != "arch-chroot /mnt chpasswd" || got.Stdin != "root:pw\n" {
+		t.Errorf("Run = %s (stdin %q), want arch-chroot /mnt chpasswd with stdin", got, got.Stdin)
+	}
+
+	if err := c.WriteFile("/etc/hostname", []byte("archbox\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.files["/mnt/etc/hostname"]; !ok {
+		t.Errorf("WriteFile wrote %v, want /mnt/etc/hostname", fake.files)
+	}
+}
+
+func TestRunWritesCheckpoint(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	inst, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("arch-chroot /mnt hwclock", "", errFake)
+	inst.Run(context.Background())
+
+	cp, err := LoadCheckpoint(inst.checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Device != "/dev/sda" || cp.Mapper != "cryptroot" {
+		t.Errorf("checkpoint = %+v, want /dev/sda with mapper cryptroot", cp)
+	}
+	if !cp.Done(PhaseBaseInstall) || cp.Done(PhaseSystemConfig) {
+		t.Errorf("completed = %v, want phases up to %s", cp.Completed, PhaseBaseInstall.Name())
+	}
+	if len(cp.Mounts) == 0 || cp.Mounts[0].Target != "/mnt" {
+		t.Errorf("mounts = %+v, want / first", cp.Mounts)
+	}
+}
+
+func TestRunResumesFromCheckpoint(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	cfg.LUKSPassphrase = "correct horse"
+	first, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("arch-chroot /mnt hwclock", "", errFake)
+	first.Run(context.Background())
+
+	cfg.Resume = true
+	inst, fake, progress := newTestInstaller(t, cfg)
+	inst.checkpointPath = first.checkpointPath
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; !last.Done {
+		t.Fatalf("last update = %+v, want Done", last)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"umount -l /mnt",
+			"cryptsetup open /dev/sda2 cryptroot",
+			"mount -o noatime,compress=zstd,subvol=@ /dev/mapper/cryptroot /mnt",
+			"mount /dev/sda1 /mnt/boot",
+			"arch-chroot /mnt hwclock",
+		},
+		[]string{"sgdisk", "luksFormat", "pacstrap", "btrfs subvolume create"},
+	)
+	if _, err := LoadCheckpoint(inst.checkpointPath); err == nil {
+		t.Error("checkpoint still present after a completed install")
+	}
+}
+
+func TestRunResumeEnablesSwap(t *testing.T) {
+	cfg := testConfig()
+	cfg.Partitions = []config.Partition{
+		{Size: "512M", Type: "efi", Mountpoint: "/boot"},
+		{Size: "4G", Type: "swap", Filesystem: "swap"},
+		{Type: "linux", Mountpoint: "/"},
+	}
+	first, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("arch-chroot /mnt hwclock", "", errFake)
+	first.Run(context.Background())
+
+	cfg.Resume = true
+	inst, fake, _ := newTestInstaller(t, cfg)
+	inst.checkpointPath = first.checkpointPath
+	inst.Run(context.Background())
+
+	assertCommands(t, fake.commands(),
+		[]string{"swapoff /dev/sda2", "mount /dev/sda1 /mnt/boot", "swapon /dev/sda2", "arch-chroot /mnt hwclock"},
+		[]string{"mkswap", "pacstrap"},
+	)
+}
+
+func TestRunResumesAlongside(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	cfg.LUKSPassphrase = "correct horse"
+	cfg.Alongside = true
+	cfg.Device.Table = windowsDisk()
+	first, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("arch-chroot /mnt hwclock", "", errFake)
+	first.Run(context.Background())
+
+	// The disk as listed again now holds the root partition, which took all
+	// of the free space
+	resumed := *cfg
+	resumed.Device.Table = windowsDisk()
+	resumed.Device.Table.Partitions = append(resumed.Device.Table.Partitions,
+		config.DiskPartition{Node: "/dev/sda3", Number: 3, Start: 105064448, Sectors: 104650719, Type: "0FC63DAF-8483-4772-8E79-3D69D8477DE4"})
+	cp, err := LoadCheckpoint(first.checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Root != "/dev/sda3" || cp.Table == nil || len(cp.Table.Partitions) != 2 {
+		t.Fatalf("checkpoint = %+v, want root /dev/sda3 and the table before the install", cp)
+	}
+	cp.Apply(&resumed)
+	resumed.Resume = true
+	if got := resumed.RootPartition(); got != "/dev/sda3" {
+		t.Errorf("RootPartition() after Apply = %q, want /dev/sda3", got)
+	}
+	if errs := fakeSystem(nil).check(&resumed); len(errs) > 0 {
+		t.Errorf("check() = %v, want no failures on resume", errs)
+	}
+
+	inst, fake, progress := newTestInstaller(t, &resumed)
+	inst.checkpointPath = first.checkpointPath
+	fake.respond("arch-chroot /mnt cat /etc/fstab", strings.Replace(targetFstab, "/boot     \t", "/efi      \t", 1), nil)
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; !last.Done {
+		t.Fatalf("last update = %+v, want Done", last)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"cryptsetup open /dev/sda3 cryptroot",
+			"mount /dev/sda1 /mnt/efi",
+			"blkid -s UUID -o value /dev/sda3",
+		},
+		[]string{"sgdisk", "/dev/sda4"},
+	)
+}
+
+func TestRunResumeRejectsOtherDevice(t *testing.T) {
+	cfg := testConfig()
+	first, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("pacstrap", "", errFake)
+	first.Run(context.Background())
+
+	cfg.Resume = true
+	cfg.Device.Name = "sdb"
+	inst, fake, progress := newTestInstaller(t, cfg)
+	inst.checkpointPath = first.checkpointPath
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; last.Err == nil {
+		t.Errorf("last update = %+v, want device mismatch error", last)
+	}
+	if cmds := fake.commands(); len(cmds) != 0 {
+		t.Errorf("ran %v, want nothing", cmds)
+	}
+}
+
+func TestRunCancelledCleansUp(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	inst, fake, progress := newTestInstaller(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake.respond("pacstrap", "", context.Canceled)
+	fake.onRun = func(c Command) {
+		if c.Name == "pacstrap" {
+			cancel()
+		}
+	}
+	inst.Run(ctx)
+
+	updates := drain(progress)
+	last := updates[len(updates)-1]
+	if !errors.Is(last.Err, context.Canceled) || last.Phase != PhaseBaseInstall {
+		t.Errorf("last update = %+v, want cancellation in %s", last, PhaseBaseInstall)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{"pacstrap", "umount -l /mnt/boot", "umount -l /mnt", "cryptsetup close cryptroot"},
+		[]string{"arch-chroot"},
+	)
+}
+
+func TestRunReportsProgressWithinPhase(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacstrap", pacstrapOutput, nil)
+	inst.Run(context.Background())
+
+	var base []float64
+	for _, u := range drain(progress) {
+		if u.Phase == PhaseBaseInstall && u.LogLine == "" {
+			base = append(base, u.Percent)
+		}
+	}
+	if len(base) < 3 {
+		t.Fatalf("base install reported %v, want progress within the phase", base)
+	}
+	for i := 1; i < len(base); i++ {
+		if base[i] < base[i-1] {
+			t.Errorf("progress went backwards: %v", base)
+		}
+	}
+	// The base install is the heaviest phase, so it should move the bar a lot
+	if span := base[len(base)-1] - base[0]; span < 0.25 {
+		t.Errorf("base install spans %.2f of the bar, want a weighted share", span)
+	}
+}
@@ -1,95 +1,81 @@
 package installer
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"os/exec"
 	"strings"
+
+	"github.com/tallenh/archy/internal/config"
 )
 
-func (inst *Installer) setupLUKS() error {
+func (inst *Installer) setupLUKS(ctx context.Context) error {
 	rootPart := inst.cfg.RootPartition()
 
 	inst.log("Formatting LUKS2 partition (pbkdf2 for GRUB compatibility)...")
-	cmd := exec.Command("cryptsetup", "luksFormat", "--type", "luks2", "--pbkdf", "pbkdf2", rootPart)
-	cmd.Stdin = strings.NewReader(inst.cfg.LUKSPassphrase + "\n")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := inst.exec.Run(ctx, Command{
+		Name:  "cryptsetup",
+		Args:  []string{"luksFormat", "--type", "luks2", "--pbkdf", "pbkdf2", rootPart},
+		Stdin: inst.cfg.LUKSPassphrase + "\n",
+	}); err != nil {
 		return fmt.Errorf("cryptsetup luksFormat: %w: %s", err, out)
 	}
 
 	inst.log("Opening LUKS device as cryptroot...")
-	cmd = exec.Command("cryptsetup", "open", rootPart, "cryptroot")
-	cmd.Stdin = strings.NewReader(inst.cfg.LUKSPassphrase + "\n")
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := inst.exec.Run(ctx, Command{
+		Name:  "cryptsetup",
+		Args:  []string{"open", rootPart, "cryptroot"},
+		Stdin: inst.cfg.LUKSPassphrase + "\n",
+	}); err != nil {
 		return fmt.Errorf("cryptsetup open: %w: %s", err, out)
 	}
 
-	inst.log("Formatting /dev/mapper/cryptroot as btrfs...")
-	return inst.run("mkfs.btrfs", "-f", "-L", "ArchRoot", "/dev/mapper/cryptroot")
+	return inst.formatRoot(ctx, "/dev/mapper/cryptroot")
 }
 
 // configureLUKSGrub sets up mkinitcpio and GRUB for LUKS-encrypted boot.
-func (inst *Installer) configureLUKSGrub() error {
+func (inst *Installer) configureLUKSGrub(ctx context.Context) error {
 	rootPart := inst.cfg.RootPartition()
 
 	// Get UUID of root partition
 	inst.log("Getting UUID of encrypted partition...")
-	out, err := exec.Command("blkid", "-s", "UUID", "-o", "value", rootPart).Output()
+	out, err := inst.exec.Run(ctx, Command{Name: "blkid", Args: []string{"-s", "UUID", "-o", "value", rootPart}})
 	if err != nil {
 		return fmt.Errorf("blkid: %w", err)
 	}
 	uuid := strings.TrimSpace(string(out))
 
-	// Update mkinitcpio.conf — add encrypt hook and btrfs to BINARIES
+	// Update mkinitcpio.conf — add encrypt hook, and btrfs to BINARIES on btrfs
 	inst.log("Configuring mkinitcpio for encryption...")
-	mkinitPath := "/mnt/etc/mkinitcpio.conf"
-	data, err := os.ReadFile(mkinitPath)
-	if err != nil {
-		return err
+	var sed []string
+	if inst.cfg.Filesystem == config.FilesystemBtrfs {
+		sed = append(sed, "-e", "s/^BINARIES=()/BINARIES=(btrfs)/")
 	}
-	content := string(data)
-
-	// Add btrfs to BINARIES
-	content = strings.Replace(content, "BINARIES=()", "BINARIES=(btrfs)", 1)
-
-	// Add encrypt hook before filesystems
-	content = strings.Replace(content,
-		"HOOKS=(base udev autodetect modconf kms keyboard keymap consolefont block filesystems fsck)",
-		"HOOKS=(base udev autodetect modconf kms keyboard keymap consolefont block encrypt filesystems fsck)",
-		1,
+	sed = append(sed,
+		"-e", "s/^HOOKS=(base udev autodetect modconf kms keyboard keymap consolefont block filesystems fsck)/HOOKS=(base udev autodetect modconf kms keyboard keymap consolefont block encrypt filesystems fsck)/",
+		"/etc/mkinitcpio.conf",
 	)
-
-	if err := os.WriteFile(mkinitPath, []byte(content), 0o644); err != nil {
+	if _, err := inst.chrootRun(ctx, "sed", append([]string{"-i"}, sed...)...); err != nil {
 		return err
 	}
 
 	// Regenerate initramfs
 	inst.log("Regenerating initramfs...")
-	if _, err := inst.chrootRun("mkinitcpio", "-P"); err != nil {
+	if _, err := inst.chrootRun(ctx, "mkinitcpio", "-P"); err != nil {
 		return err
 	}
 
-	// Set GRUB_CMDLINE_LINUX for cryptdevice
+	// Set GRUB_CMDLINE_LINUX for cryptdevice and enable GRUB cryptodisk support
 	inst.log("Configuring GRUB for encrypted root...")
-	grubDefault := "/mnt/etc/default/grub"
-	grubData, err := os.ReadFile(grubDefault)
-	if err != nil {
-		return err
+	// dm-crypt drops discards unless allowed, which would make TRIM a no-op
+	var discards string
+	if inst.cfg.SSD() {
+		discards = ":allow-discards"
 	}
-	grubContent := string(grubData)
-	cryptArg := fmt.Sprintf("cryptdevice=UUID=%s:cryptroot root=/dev/mapper/cryptroot", uuid)
-	grubContent = strings.Replace(grubContent,
-		`GRUB_CMDLINE_LINUX=""`,
-		fmt.Sprintf(`GRUB_CMDLINE_LINUX="%s"`, cryptArg),
-		1,
+	cryptArg := fmt.Sprintf("cryptdevice=UUID=%s:cryptroot%s root=/dev/mapper/cryptroot", uuid, discards)
+	_, err = inst.chrootRun(ctx, "sed", "-i",
+		"-e", fmt.Sprintf(`s|^GRUB_CMDLINE_LINUX=""|GRUB_CMDLINE_LINUX="%s"|`, cryptArg),
+		"-e", "s/^#GRUB_ENABLE_CRYPTODISK=y/GRUB_ENABLE_CRYPTODISK=y/",
+		"/etc/default/grub",
 	)
-
-	// Enable GRUB cryptodisk support
-	grubContent = strings.Replace(grubContent,
-		"#GRUB_ENABLE_CRYPTODISK=y",
-		"GRUB_ENABLE_CRYPTODISK=y",
-		1,
-	)
-
-	return os.WriteFile(grubDefault, []byte(grubContent), 0o644)
+	return err
 }
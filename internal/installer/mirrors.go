@@ -0,0 +1,71 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MirrorlistPath is pacman's mirror list, on the live system and in the target.
+const MirrorlistPath = "/etc/pacman.d/mirrorlist"
+
+// mirrorServer turns a configured mirror into a mirrorlist Server value. A
+// bare mirror root gets the standard $repo/os/$arch layout appended.
+func mirrorServer(mirror string) string {
+	if strings.Contains(mirror, "$repo") {
+		return mirror
+	}
+	return strings.TrimSuffix(mirror, "/") + "/$repo/os/$arch"
+}
+
+// mirrorlist returns the mirror list for the configured mirrors and
+// countries, or "" when neither is set and the ISO's list should be kept.
+// Explicit mirrors come first; reflector ranks mirrors in the countries. It
+// is generated once and reused for the target.
+func (inst *Installer) mirrorlist(ctx context.Context) (string, error) {
+	if inst.mirrors != "" || len(inst.cfg.Mirrors)+len(inst.cfg.MirrorCountries) == 0 {
+		return inst.mirrors, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("# Written by archy\n")
+	for _, m := range inst.cfg.Mirrors {
+		fmt.Fprintf(&b, "Server = %s\n", mirrorServer(m))
+	}
+	if len(inst.cfg.MirrorCountries) > 0 {
+		countries := strings.Join(inst.cfg.MirrorCountries, ",")
+		inst.log("Ranking mirrors in " + countries + "...")
+		inst.logToFile("RUN   reflector --country %s", countries)
+		out, err := inst.exec.Run(ctx, Command{Name: "reflector", Args: []string{
+			"--country", countries, "--protocol", "https", "--latest", "20", "--sort", "rate",
+		}})
+		if err != nil {
+			return "", fmt.Errorf("reflector: %w: %s", err, out)
+		}
+		found := false
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "Server") {
+				b.WriteString(strings.TrimSpace(line) + "\n")
+				found = true
+			}
+		}
+		if !found && !inst.cfg.DryRun {
+			return "", fmt.Errorf("reflector found no mirrors in %s", countries)
+		}
+	}
+	inst.mirrors = b.String()
+	return inst.mirrors, nil
+}
+
+// writeMirrorlist writes the mirror list through e, if one is configured.
+func (inst *Installer) writeMirrorlist(ctx context.Context, e Executor, where string) error {
+	list, err := inst.mirrorlist(ctx)
+	if err != nil || list == "" {
+		return err
+	}
+	inst.log("Writing mirror list for the " + where + "...")
+	if err := e.WriteFile(MirrorlistPath, []byte(list), 0o644); err != nil {
+		return fmt.Errorf("write %s mirrorlist: %w", where, err)
+	}
+	return nil
+}
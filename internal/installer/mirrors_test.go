@@ -0,0 +1,66 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const reflectorOutput = `################################################################################
+################# Arch Linux mirrorlist generated by Reflector #################
+################################################################################
+
+Server = https://mirror.example.de/archlinux/$repo/os/$arch
+Server = https://ftp.example.de/pub/archlinux/$repo/os/$arch
+`
+
+func TestRunWritesMirrorlist(t *testing.T) {
+	cfg := testConfig()
+	cfg.Mirrors = []string{"http://10.0.0.5/archlinux/", "https://cache.lab/$repo/os/$arch"}
+	cfg.MirrorCountries = []string{"DE", "Austria"}
+	inst, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("reflector", reflectorOutput, nil)
+	inst.Run(context.Background())
+
+	want := "# Written by archy\n" +
+		"Server = http://10.0.0.5/archlinux/$repo/os/$arch\n" +
+		"Server = https://cache.lab/$repo/os/$arch\n" +
+		"Server = https://mirror.example.de/archlinux/$repo/os/$arch\n" +
+		"Server = https://ftp.example.de/pub/archlinux/$repo/os/$arch\n"
+	if got := fake.files[MirrorlistPath]; got != want {
+		t.Errorf("live mirrorlist = %q, want %q", got, want)
+	}
+	if got := fake.files["/mnt"+MirrorlistPath]; got != want {
+		t.Errorf("target mirrorlist = %q, want the live one", got)
+	}
+	cmds := fake.commands()
+	assertCommands(t, cmds, []string{"reflector --country DE,Austria", "pacstrap"}, nil)
+	if n := countPrefix(cmds, "reflector"); n != 1 {
+		t.Errorf("reflector ran %d times, want once", n)
+	}
+}
+
+func TestRunKeepsISOMirrorlist(t *testing.T) {
+	inst, fake, _ := newTestInstaller(t, testConfig())
+	inst.Run(context.Background())
+
+	if _, ok := fake.files[MirrorlistPath]; ok {
+		t.Error("wrote a mirrorlist without mirrors configured")
+	}
+	assertCommands(t, fake.commands(), nil, []string{"reflector"})
+}
+
+func TestRunFailsWithoutMirrors(t *testing.T) {
+	cfg := testConfig()
+	cfg.MirrorCountries = []string{"Atlantis"}
+	inst, fake, progress := newTestInstaller(t, cfg)
+	fake.respond("reflector", "# no mirrors\n", nil)
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	last := updates[len(updates)-1]
+	if last.Err == nil || !strings.Contains(last.Err.Error(), "no mirrors in Atlantis") {
+		t.Errorf("last update = %+v, want reflector failure", last)
+	}
+	assertCommands(t, fake.commands(), nil, []string{"pacstrap"})
+}
@@ -0,0 +1,95 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// OfflineRepoDir is where the offline repository appears in the installed
+// system, and where a repository from archy.zip is unpacked on the live one.
+const OfflineRepoDir = "/var/cache/archy/repo"
+
+// offlineRepoTarget is the bind mount of the repository under TargetRoot.
+const offlineRepoTarget = TargetRoot + OfflineRepoDir
+
+// liveRepoDir returns the directory of the offline repository on the live
+// system.
+func (inst *Installer) liveRepoDir() string {
+	if inst.cfg.OfflineRepo.Bundle != "" {
+		return OfflineRepoDir
+	}
+	return inst.cfg.OfflineRepo.Dir
+}
+
+// offlinePacmanConf returns a pacman.conf whose only repository is the
+// offline one at dir. Packages in it are usually built or mirrored locally,
+// so they need not be signed.
+func offlinePacmanConf(name, dir string) string {
+	return fmt.Sprintf(`# Written by archy for an offline install
+[options]
+HoldPkg     = pacman glibc
+Architecture = auto
+CheckSpace
+SigLevel    = Required DatabaseOptional
+LocalFileSigLevel = Optional
+
+[%s]
+SigLevel = Optional TrustAll
+Server = file://%s
+`, name, dir)
+}
+
+// useOfflineRepo unpacks a repository shipped in archy.zip and points the
+// live system's pacman, and so pacstrap, at the offline repository.
+func (inst *Installer) useOfflineRepo(ctx context.Context) error {
+	repo := inst.cfg.OfflineRepo
+	if repo.Bundle != "" {
+		inst.log("Unpacking offline repository " + repo.Dir + "...")
+		if err := inst.exec.MkdirAll(OfflineRepoDir, 0o755); err != nil {
+			return fmt.Errorf("mkdir %s: %w", OfflineRepoDir, err)
+		}
+		args := []string{"-xf", repo.Bundle, "-C", OfflineRepoDir}
+		if repo.Dir != "." {
+			strip := strconv.Itoa(strings.Count(repo.Dir, "/") + 1)
+			args = append(args, "--strip-components", strip, repo.Dir)
+		}
+		if err := inst.run(ctx, "bsdtar", args...); err != nil {
+			return err
+		}
+		// The zip dropped the NAME.db link repo-add made, and pacman reads
+		// nothing else
+		if db := repo.Name + ".db"; repo.Database != "" && repo.Database != db {
+			if err := inst.run(ctx, "cp", path.Join(OfflineRepoDir, repo.Database), path.Join(OfflineRepoDir, db)); err != nil {
+				return err
+			}
+		}
+	}
+
+	inst.log("Using offline repository " + repo.Name + " at " + inst.liveRepoDir())
+	conf := offlinePacmanConf(repo.Name, inst.liveRepoDir())
+	if err := inst.exec.WriteFile("/etc/pacman.conf", []byte(conf), 0o644); err != nil {
+		return fmt.Errorf("write /etc/pacman.conf: %w", err)
+	}
+	return nil
+}
+
+// bindOfflineRepo makes the offline repository available inside the target
+// for the pacman runs that follow pacstrap, and points the target's pacman
+// at it. The installed system keeps that pacman.conf: mounting the
+// repository at OfflineRepoDir lets it install more packages offline.
+func (inst *Installer) bindOfflineRepo(ctx context.Context) error {
+	if err := inst.exec.MkdirAll(offlineRepoTarget, 0o755); err != nil {
+		return fmt.Errorf("mkdir %s: %w", offlineRepoTarget, err)
+	}
+	if err := inst.mount(ctx, MountPoint{inst.liveRepoDir(), offlineRepoTarget, "bind,ro"}); err != nil {
+		return err
+	}
+	conf := offlinePacmanConf(inst.cfg.OfflineRepo.Name, OfflineRepoDir)
+	if err := inst.target.WriteFile("/etc/pacman.conf", []byte(conf), 0o644); err != nil {
+		return fmt.Errorf("write target pacman.conf: %w", err)
+	}
+	return nil
+}
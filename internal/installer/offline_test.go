@@ -0,0 +1,100 @@
+package installer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// offlineBundle returns an archy.zip file system holding a repository
+// database in repo/ that offers pkgs.
+func offlineBundle(t *testing.T, pkgs ...string) fstest.MapFS {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, p := range pkgs {
+		desc := "%NAME%\n" + p + "\n"
+		if err := tw.WriteHeader(&tar.Header{Name: p + "-1.0-1/desc", Mode: 0o644, Size: int64(len(desc))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(desc))
+	}
+	tw.Close()
+	zw.Close()
+	return fstest.MapFS{"repo/archy.db.tar.gz": {Data: buf.Bytes()}}
+}
+
+func TestRunOfflineRepo(t *testing.T) {
+	cfg := testConfig()
+	cfg.OfflineRepo = &config.OfflineRepo{Name: "archy", Dir: "/run/media/usb/repo"}
+	inst, fake, _ := newTestInstaller(t, cfg)
+	usb := fstest.MapFS{}
+	// repo-add's NAME.db link survives on a USB stick
+	for name, f := range offlineBundle(t, requiredPackages(cfg)...) {
+		usb["run/media/usb/"+strings.TrimSuffix(name, ".tar.gz")] = f
+	}
+	inst.probe = fakeSystem(usb)
+	fake.respond("systemd-detect-virt", "kvm\n", nil)
+	inst.Run(context.Background())
+
+	if got := fake.files["/etc/pacman.conf"]; !strings.Contains(got, "[archy]") || !strings.Contains(got, "Server = file:///run/media/usb/repo\n") {
+		t.Errorf("live pacman.conf = %q, want only the offline repository", got)
+	}
+	if got := fake.files["/mnt/etc/pacman.conf"]; !strings.Contains(got, "Server = file://"+OfflineRepoDir+"\n") {
+		t.Errorf("target pacman.conf = %q, want the repository at %s", got, OfflineRepoDir)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"pacstrap /mnt base",
+			"mount -o bind,ro /run/media/usb/repo /mnt/var/cache/archy/repo",
+			"arch-chroot /mnt systemctl enable NetworkManager",
+		},
+		[]string{"bsdtar", "git clone", "qemu-guest-agent", "pacman -Syw"},
+	)
+}
+
+func TestRunOfflineRepoFromBundle(t *testing.T) {
+	cfg := testConfig()
+	cfg.OfflineRepo = &config.OfflineRepo{Name: "archy", Dir: "repo", Bundle: "/root/archy.zip"}
+	cfg.BundleFS = offlineBundle(t, requiredPackages(cfg)...)
+	inst, fake, _ := newTestInstaller(t, cfg)
+	inst.Run(context.Background())
+
+	if got := fake.files["/etc/pacman.conf"]; !strings.Contains(got, "Server = file://"+OfflineRepoDir+"\n") {
+		t.Errorf("live pacman.conf = %q, want the unpacked repository", got)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"bsdtar -xf /root/archy.zip -C /var/cache/archy/repo --strip-components 1 repo",
+			"cp /var/cache/archy/repo/archy.db.tar.gz /var/cache/archy/repo/archy.db",
+			"pacstrap /mnt base",
+			"mount -o bind,ro /var/cache/archy/repo /mnt/var/cache/archy/repo",
+		},
+		nil,
+	)
+}
+
+func TestPreflightOfflineRepo(t *testing.T) {
+	cfg := testConfig()
+	cfg.Desktop = config.DesktopHyprland
+	cfg.BundleFS = offlineBundle(t, requiredPackages(testConfig())...)
+	cfg.OfflineRepo = &config.OfflineRepo{Dir: "repo", Bundle: "/root/archy.zip"}
+
+	errs := fakeSystem(nil, "bsdtar").check(cfg)
+	if len(errs) != 2 {
+		t.Fatalf("check = %v, want missing bsdtar and desktop packages", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "bsdtar") {
+		t.Errorf("first failure = %v, want missing bsdtar", errs[0])
+	}
+	if got := errs[1].Error(); !strings.Contains(got, "lacks hyprland, kitty, wofi, sddm") {
+		t.Errorf("second failure = %v, want the desktop packages", got)
+	}
+}
@@ -0,0 +1,133 @@
+package installer
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// UnknownPackageError reports a requested package that no sync database
+// offers, with the closest names that do exist.
+type UnknownPackageError struct {
+	Name        string
+	Suggestions []string
+}
+
+func (e *UnknownPackageError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("package %s not found", e.Name)
+	}
+	return fmt.Sprintf("package %s not found, did you mean %s?", e.Name, strings.Join(e.Suggestions, ", "))
+}
+
+// checkPackages resolves pkgs against the sync databases, as pacstrap
+// would, and returns one error per package, group or provision that cannot
+// be found. The databases are refreshed first if they have never been,
+// unless refresh is false: a dry run leaves the live system alone and skips
+// the check instead.
+func (p probe) checkPackages(pkgs []string, refresh bool) []error {
+	args := append([]string{"-Sp", "--print-format", "%n", "--"}, pkgs...)
+	out, err := p.pacman(args...)
+	if err != nil && strings.Contains(string(out), "use '-Sy'") {
+		if !refresh {
+			return nil
+		}
+		if out, err := p.pacman("-Sy"); err != nil {
+			return []error{fmt.Errorf("refresh package databases: %v: %s", err, firstLine(out))}
+		}
+		out, err = p.pacman(args...)
+	}
+	if err == nil {
+		return nil
+	}
+
+	var unknown []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, ok := strings.CutPrefix(strings.TrimSpace(line), "error: target not found: "); ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return []error{fmt.Errorf("resolve packages: %v: %s", err, firstLine(out))}
+	}
+
+	known := p.syncNames()
+	var errs []error
+	for _, name := range unknown {
+		errs = append(errs, &UnknownPackageError{Name: name, Suggestions: closeMatches(name, known, 3)})
+	}
+	return errs
+}
+
+// syncNames returns every package and group name in the sync databases.
+func (p probe) syncNames() []string {
+	var names []string
+	for _, args := range [][]string{{"-Slq"}, {"-Sg"}} {
+		out, err := p.pacman(args...)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if f := strings.Fields(line); len(f) > 0 {
+				names = append(names, f[0])
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// closeMatches returns up to n names within a small edit distance of name,
+// closest first.
+func closeMatches(name string, names []string, n int) []string {
+	type match struct {
+		name string
+		dist int
+	}
+	limit := max(1, min(3, len(name)/3))
+	var matches []match
+	for _, candidate := range names {
+		if d := editDistance(name, candidate); d <= limit {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return cmp.Compare(a.dist, b.dist) })
+	var out []string
+	for _, m := range matches[:min(n, len(matches))] {
+		out = append(out, m.name)
+	}
+	return out
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// the Levenshtein distance with swapped adjacent letters counting as one
+// edit, as they are the commonest typo.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func firstLine(out []byte) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return line
+}
@@ -0,0 +1,110 @@
+package installer
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheckPackages(t *testing.T) {
+	synced := false
+	var calls []string
+	p := fakeSystem(nil)
+	p.pacman = func(args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		switch {
+		case args[0] == "-Sy":
+			synced = true
+			return nil, nil
+		case args[0] == "-Sp" && !synced:
+			return []byte("error: database file for 'core' does not exist (use '-Sy' first)\n"), errFake
+		case args[0] == "-Sp":
+			return []byte("error: target not found: neovmi\nerror: target not found: gnmoe\n"), errFake
+		case args[0] == "-Slq":
+			return []byte("neovim\nneomutt\nvim\ngnome-shell\n"), nil
+		case args[0] == "-Sg":
+			return []byte("gnome gdm\ngnome nautilus\nxorg xorg-server\n"), nil
+		}
+		return nil, nil
+	}
+
+	errs := p.checkPackages([]string{"base", "neovmi", "gnmoe"}, true)
+	want := []UnknownPackageError{
+		{Name: "neovmi", Suggestions: []string{"neovim"}},
+		{Name: "gnmoe", Suggestions: []string{"gnome"}},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("checkPackages = %v, want %d unknown packages", errs, len(want))
+	}
+	for i, err := range errs {
+		var u *UnknownPackageError
+		if !errors.As(err, &u) || u.Name != want[i].Name || !slices.Equal(u.Suggestions, want[i].Suggestions) {
+			t.Errorf("error %d = %v, want %+v", i, err, want[i])
+		}
+	}
+	if calls[1] != "-Sy" || calls[2] != "-Sp --print-format %n -- base neovmi gnmoe" {
+		t.Errorf("pacman calls = %q, want a refresh and a retry", calls)
+	}
+	if got := errs[0].Error(); got != "package neovmi not found, did you mean neovim?" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestCheckPackagesOtherFailure(t *testing.T) {
+	p := fakeSystem(nil)
+	p.pacman = func(args ...string) ([]byte, error) {
+		return []byte("error: failed to init transaction (unable to lock database)\n"), errFake
+	}
+	errs := p.checkPackages([]string{"base"}, true)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unable to lock database") {
+		t.Errorf("checkPackages = %v, want the pacman error", errs)
+	}
+}
+
+func TestPreflightDryRunLeavesDatabases(t *testing.T) {
+	cfg := testConfig()
+	cfg.DryRun = true
+	var calls []string
+	p := fakeSystem(nil)
+	p.pacman = func(args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return []byte("error: database file for 'core' does not exist (use '-Sy' first)\n"), errFake
+	}
+	if errs := p.check(cfg); len(errs) != 0 {
+		t.Fatalf("check = %v, want the package check skipped", errs)
+	}
+	if slices.Contains(calls, "-Sy") {
+		t.Errorf("pacman calls = %q, want no refresh in a dry run", calls)
+	}
+}
+
+func TestPreflightChecksRequiredPackages(t *testing.T) {
+	cfg := testConfig()
+	cfg.Packages = []string{"tmux"}
+	var resolved []string
+	p := fakeSystem(nil)
+	p.pacman = func(args ...string) ([]byte, error) {
+		resolved = args
+		return nil, nil
+	}
+	if errs := p.check(cfg); len(errs) != 0 {
+		t.Fatalf("check = %v", errs)
+	}
+	if !slices.Contains(resolved, "grub") || resolved[len(resolved)-1] != "tmux" {
+		t.Errorf("resolved %v, want every required package", resolved)
+	}
+}
+
+func TestCloseMatches(t *testing.T) {
+	names := []string{"firefox", "firejail", "neovim", "vim", "vi"}
+	if got := closeMatches("firefx", names, 3); !slices.Equal(got, []string{"firefox"}) {
+		t.Errorf("closeMatches(firefx) = %v", got)
+	}
+	if got := closeMatches("vm", names, 3); !slices.Equal(got, []string{"vim", "vi"}) {
+		t.Errorf("closeMatches(vm) = %v", got)
+	}
+	if got := closeMatches("emacs", names, 3); got != nil {
+		t.Errorf("closeMatches(emacs) = %v, want none", got)
+	}
+}
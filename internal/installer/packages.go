@@ -0,0 +1,82 @@
+package installer
+
+import (
+	"context"
+	"slices"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// basePackages are the core of every install. The tools of the filesystems
+// in the partition layout follow them.
+var basePackages = []string{"base", "linux", "linux-firmware", "sudo", "vim"}
+
+// guestAgents are added when archy runs in a QEMU/KVM guest.
+var guestAgents = []string{"qemu-guest-agent", "spice-vdagent"}
+
+// requiredPackages returns every repository package the install needs for
+// cfg. Guest agents, which depend on the machine rather than the
+// configuration, are left out.
+func requiredPackages(cfg *config.InstallConfig) []string {
+	pkgs := append([]string{}, basePackages...)
+	for _, p := range cfg.PartitionLayout() {
+		if f, err := config.ParseFilesystem(partitionFS(cfg, p)); err == nil {
+			for _, pkg := range f.Packages() {
+				if !slices.Contains(pkgs, pkg) {
+					pkgs = append(pkgs, pkg)
+				}
+			}
+		}
+	}
+	if cfg.Shell == "zsh" {
+		pkgs = append(pkgs, "zsh")
+	}
+	pkgs = append(pkgs, "zram-generator", "grub", "efibootmgr", "networkmanager")
+	if cfg.Alongside {
+		pkgs = append(pkgs, "os-prober")
+	}
+	if cfg.SSHD {
+		pkgs = append(pkgs, "openssh")
+	}
+	if cfg.Docker {
+		pkgs = append(pkgs, "docker")
+	}
+	pkgs = append(pkgs, cfg.Desktop.Packages()...)
+	pkgs = append(pkgs, "base-devel", "git", "go")
+	return append(pkgs, cfg.Packages...)
+}
+
+// packageSet returns the packages installBase installs in its single pacstrap
+// transaction: the required ones, plus the guest agents in a QEMU guest
+// unless an offline repository lacks them. It is computed once.
+func (inst *Installer) packageSet(ctx context.Context) []string {
+	if inst.packages != nil {
+		return inst.packages
+	}
+	pkgs := requiredPackages(inst.cfg)
+	if inst.isQEMU(ctx) {
+		if inst.offlineHas(guestAgents) {
+			pkgs = append(pkgs, guestAgents...)
+		} else {
+			inst.log("Warning: guest agents are not in the offline repository, skipping")
+		}
+	}
+	var set []string
+	for _, p := range pkgs {
+		if !slices.Contains(set, p) {
+			set = append(set, p)
+		}
+	}
+	inst.packages = set
+	return set
+}
+
+// offlineHas reports whether pkgs can be installed: always when installing
+// from the network, otherwise only if the offline repository has them all.
+func (inst *Installer) offlineHas(pkgs []string) bool {
+	if inst.cfg.OfflineRepo == nil {
+		return true
+	}
+	ix, err := inst.probe.offlineIndex(inst.cfg)
+	return err == nil && len(ix.Missing(pkgs)) == 0
+}
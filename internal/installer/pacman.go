@@ -0,0 +1,83 @@
+package installer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	pacmanPackagesRe = regexp.MustCompile(`^Packages \((\d+)\)`)
+	pacmanHookRe     = regexp.MustCompile(`^\((\s*\d+)/(\d+)\) `)
+	pacmanPackageRe  = regexp.MustCompile(`^(installing|upgrading|reinstalling|downgrading) \S+\.\.\.$`)
+)
+
+// pacmanProgress estimates how far a pacman or pacstrap transaction has got
+// by watching its output: downloads, then one "installing foo..." line per
+// package, then "(1/20) …" post-transaction hooks. Its output is piped, so
+// pacman prints these plain lines instead of progress bars.
+type pacmanProgress struct {
+	total      int // packages in the transaction
+	downloaded int
+	installed  int
+	hooks      bool // post-transaction hooks have started
+	hook       int
+	hookTotal  int
+}
+
+// isPacman reports whether cmd runs a package transaction worth tracking.
+func isPacman(cmd Command) bool {
+	return cmd.Name == "pacstrap" || cmd.Name == "pacman"
+}
+
+// update feeds one line of output to the tracker and reports whether the
+// estimated fraction may have changed.
+func (p *pacmanProgress) update(line string) bool {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, ":: Running post-transaction hooks"):
+		p.hooks = true
+		return true
+	case strings.HasSuffix(line, " downloading..."):
+		// Sync databases are downloaded before the package list
+		if p.total == 0 {
+			return false
+		}
+		p.downloaded++
+		return true
+	case !p.hooks && pacmanPackageRe.MatchString(line):
+		p.installed++
+		return p.total > 0
+	}
+	if m := pacmanPackagesRe.FindStringSubmatch(line); m != nil {
+		p.total, _ = strconv.Atoi(m[1])
+		return false
+	}
+	m := pacmanHookRe.FindStringSubmatch(line)
+	if m == nil || !p.hooks {
+		return false
+	}
+	p.hook, _ = strconv.Atoi(strings.TrimSpace(m[1]))
+	p.hookTotal, _ = strconv.Atoi(m[2])
+	return true
+}
+
+// fraction returns the estimated completion of the transaction in [0, 1].
+// Downloads count for 40%, installation for 50% and hooks for the last 10%.
+func (p *pacmanProgress) fraction() float64 {
+	var dl, in, hk float64
+	if p.total > 0 {
+		dl = min(float64(p.downloaded)/float64(p.total), 1)
+		in = min(float64(p.installed)/float64(p.total), 1)
+	}
+	if p.installed > 0 {
+		dl = 1 // anything not downloaded came from the cache
+	}
+	if p.hooks {
+		dl, in = 1, 1
+		if p.hookTotal > 0 {
+			hk = float64(p.hook) / float64(p.hookTotal)
+		}
+	}
+	return 0.4*dl + 0.5*in + 0.1*hk
+}
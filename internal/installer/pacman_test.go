@@ -0,0 +1,81 @@
+package installer
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// pacstrapOutput is pacstrap's output when piped, as archy runs it, with the
+// package list cut short.
+const pacstrapOutput = `==> Creating install root at /mnt
+==> Installing packages to /mnt
+:: Synchronizing package databases...
+ core downloading...
+ extra downloading...
+resolving dependencies...
+looking for conflicting packages...
+
+Packages (4) iana-etc-20240305-1  filesystem-2024.04.07-1  linux-6.9.1-1  base-3-2
+
+Total Download Size:   150.00 MiB
+Total Installed Size:  300.00 MiB
+
+:: Proceed with installation? [Y/n] 
+:: Retrieving packages...
+ iana-etc-20240305-1-any downloading...
+ filesystem-2024.04.07-1-any downloading...
+checking keyring...
+checking package integrity...
+loading package files...
+checking for file conflicts...
+checking available disk space...
+:: Processing package changes...
+installing iana-etc...
+installing filesystem...
+installing linux...
+Optional dependencies for linux
+    linux-firmware: firmware images needed for some devices [pending]
+installing base...
+Optional dependencies for base
+    linux: bare metal support [pending]
+:: Running post-transaction hooks...
+(1/2) Creating system user accounts...
+(2/2) Updating linux initcpios...`
+
+func TestPacmanProgress(t *testing.T) {
+	var p pacmanProgress
+	var fractions []float64
+	for _, line := range strings.Split(pacstrapOutput, "\n") {
+		if p.update(line) {
+			fractions = append(fractions, p.fraction())
+		}
+	}
+
+	want := []float64{
+		0.1, 0.2, // two of four packages downloaded
+		0.4 + 0.125, 0.4 + 0.25, 0.4 + 0.375, 0.9, // installs; the rest came from cache
+		0.9, 0.95, 1.0, // hooks
+	}
+	if len(fractions) != len(want) {
+		t.Fatalf("fractions = %v, want %v", fractions, want)
+	}
+	for i := range want {
+		if math.Abs(fractions[i]-want[i]) > 1e-9 {
+			t.Errorf("fractions[%d] = %v, want %v", i, fractions[i], want[i])
+		}
+	}
+}
+
+func TestPacmanProgressIgnoresUnknownCounters(t *testing.T) {
+	var p pacmanProgress
+	if p.update("(4/4) checking keys in keyring") {
+		t.Error("keyring check counted as progress")
+	}
+	if p.update("(1/4) installing iana-etc") {
+		t.Error("counter before the hooks counted as progress")
+	}
+	if p.fraction() != 0 {
+		t.Errorf("fraction() = %v, want 0", p.fraction())
+	}
+}
@@ -1,59 +1,309 @@
 package installer
 
-// Phase identifies each installation phase.
-type Phase int
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// Phase identifies an installation phase by its stable name, as used in
+// checkpoint files, hooks and --only-phases.
+type Phase string
 
 const (
-	PhasePrepare Phase = iota
-	PhasePartition
-	PhaseLUKS
-	PhaseBtrfs
-	PhaseBaseInstall
-	PhaseSystemConfig
-	PhaseSwap
-	PhaseBootloader
-	PhaseServices
-	PhaseSSHD
-	PhaseDocker
-	PhaseDesktop
-	PhaseSoftware
-	PhaseDotfiles
-	phaseCount
+	PhasePreflight    Phase = "preflight"
+	PhasePrepare      Phase = "prepare"
+	PhasePartition    Phase = "partition"
+	PhaseLUKS         Phase = "luks"
+	PhaseFilesystem   Phase = "filesystem"
+	PhaseBaseInstall  Phase = "base_install"
+	PhaseSystemConfig Phase = "system_config"
+	PhaseSwap         Phase = "swap"
+	PhaseBootloader   Phase = "bootloader"
+	PhaseServices     Phase = "services"
+	PhaseSSHD         Phase = "sshd"
+	PhaseDocker       Phase = "docker"
+	PhaseDesktop      Phase = "desktop"
+	PhaseSoftware     Phase = "software"
+	PhaseDotfiles     Phase = "dotfiles"
+	PhaseVerify       Phase = "verify"
 )
 
+// PhaseDef is everything the installer knows about a phase. Adding a phase
+// means adding its definition to the registry; Run needs no changes.
+type PhaseDef struct {
+	Phase       Phase
+	Description string
+	Weight      float64                              // expected relative cost, used to apportion the progress bar
+	Deps        []Phase                              // phases that must have completed (or been skipped) first
+	Skip        func(cfg *config.InstallConfig) bool // nil means the phase always runs
+	Critical    bool                                 // the install cannot continue without it, so it may not be skipped after failing
+	Disk        bool                                 // sets up partitions, LUKS or mounts: a retry first resets them, as a resume does
+	run         func(inst *Installer, ctx context.Context) error
+}
+
+// registry lists the phases in the order they run. It is filled in by init
+// because the phase functions themselves look phases up.
+var registry []PhaseDef
+
+func init() {
+	dryRun := func(cfg *config.InstallConfig) bool { return cfg.DryRun }
+	registry = []PhaseDef{
+		{
+			Phase:       PhasePreflight,
+			Description: "Running pre-flight checks",
+			Weight:      1,
+			Skip:        dryRun,
+			Critical:    true,
+			run:         (*Installer).preflight,
+		},
+		{
+			Phase:       PhasePrepare,
+			Description: "Preparing system",
+			Weight:      1,
+			Critical:    true,
+			run:         (*Installer).prepare,
+		},
+		{
+			Phase:       PhasePartition,
+			Description: "Partitioning disk",
+			Weight:      2,
+			Deps:        []Phase{PhasePreflight},
+			Critical:    true,
+			Disk:        true,
+			run:         (*Installer).partition,
+		},
+		{
+			Phase:       PhaseLUKS,
+			Description: "Setting up LUKS encryption",
+			Weight:      3,
+			Deps:        []Phase{PhasePartition},
+			Skip:        func(cfg *config.InstallConfig) bool { return !cfg.Encrypt },
+			Critical:    true,
+			Disk:        true,
+			run:         (*Installer).setupLUKS,
+		},
+		{
+			Phase:       PhaseFilesystem,
+			Description: "Mounting filesystems",
+			Weight:      1,
+			Deps:        []Phase{PhasePartition, PhaseLUKS},
+			Critical:    true,
+			Disk:        true,
+			run:         (*Installer).mountFilesystems,
+		},
+		{
+			Phase:       PhaseBaseInstall,
+			Description: "Installing base system",
+			Weight:      70,
+			Deps:        []Phase{PhasePrepare, PhaseFilesystem},
+			Critical:    true,
+			run:         (*Installer).installBase,
+		},
+		{
+			Phase:       PhaseSystemConfig,
+			Description: "Configuring system",
+			Weight:      3,
+			Deps:        []Phase{PhaseBaseInstall},
+			Critical:    true,
+			run:         (*Installer).configureSystem,
+		},
+		{
+			Phase:       PhaseSwap,
+			Description: "Setting up ZRAM swap",
+			Weight:      1,
+			Deps:        []Phase{PhaseBaseInstall},
+			run:         (*Installer).configureSwap,
+		},
+		{
+			Phase:       PhaseBootloader,
+			Description: "Installing bootloader",
+			Weight:      4,
+			Deps:        []Phase{PhaseBaseInstall},
+			Critical:    true,
+			run:         (*Installer).installBootloader,
+		},
+		{
+			Phase:       PhaseServices,
+			Description: "Enabling services",
+			Weight:      2,
+			Deps:        []Phase{PhaseBaseInstall},
+			run:         (*Installer).enableServices,
+		},
+		{
+			Phase:       PhaseSSHD,
+			Description: "Configuring SSH server",
+			Weight:      1,
+			Deps:        []Phase{PhaseSystemConfig},
+			Skip:        func(cfg *config.InstallConfig) bool { return !cfg.SSHD },
+			run:         (*Installer).configureSSHD,
+		},
+		{
+			Phase:       PhaseDocker,
+			Description: "Configuring Docker",
+			Weight:      1,
+			Deps:        []Phase{PhaseSystemConfig},
+			Skip:        func(cfg *config.InstallConfig) bool { return !cfg.Docker },
+			run:         (*Installer).installDocker,
+		},
+		{
+			Phase:       PhaseDesktop,
+			Description: "Configuring desktop environment",
+			Weight:      1,
+			Deps:        []Phase{PhaseBaseInstall},
+			Skip:        func(cfg *config.InstallConfig) bool { return cfg.Desktop == config.DesktopNone },
+			run:         (*Installer).installDesktop,
+		},
+		{
+			Phase:       PhaseSoftware,
+			Description: "Installing AUR software",
+			Weight:      10,
+			Deps:        []Phase{PhaseSystemConfig},
+			run:         (*Installer).installSoftware,
+		},
+		{
+			Phase:       PhaseDotfiles,
+			Description: "Installing dotfiles",
+			Weight:      1,
+			Deps:        []Phase{PhaseSystemConfig},
+			Skip:        func(cfg *config.InstallConfig) bool { return len(cfg.Dotfiles) == 0 },
+			run:         (*Installer).installDotfiles,
+		},
+		{
+			Phase:       PhaseVerify,
+			Description: "Verifying installation",
+			Weight:      1,
+			Deps:        []Phase{PhaseSystemConfig, PhaseBootloader, PhaseServices},
+			Skip:        dryRun,
+			run:         (*Installer).verify,
+		},
+	}
+}
+
+// Phases returns the registered phases in the order they run.
+func Phases() []PhaseDef {
+	return slices.Clone(registry)
+}
+
+// lookup returns the definition of p.
+func (p Phase) lookup() (PhaseDef, bool) {
+	i := slices.IndexFunc(registry, func(d PhaseDef) bool { return d.Phase == p })
+	if i < 0 {
+		return PhaseDef{}, false
+	}
+	return registry[i], true
+}
+
+// before reports whether p runs before q.
+func (p Phase) before(q Phase) bool {
+	index := func(p Phase) int { return slices.IndexFunc(registry, func(d PhaseDef) bool { return d.Phase == p }) }
+	return index(p) < index(q)
+}
+
 func (p Phase) String() string {
-	switch p {
-	case PhasePrepare:
-		return "Preparing system"
-	case PhasePartition:
-		return "Partitioning disk"
-	case PhaseLUKS:
-		return "Setting up LUKS encryption"
-	case PhaseBtrfs:
-		return "Configuring btrfs subvolumes"
-	case PhaseBaseInstall:
-		return "Installing base system"
-	case PhaseSystemConfig:
-		return "Configuring system"
-	case PhaseSwap:
-		return "Setting up ZRAM swap"
-	case PhaseBootloader:
-		return "Installing bootloader"
-	case PhaseServices:
-		return "Enabling services"
-	case PhaseSSHD:
-		return "Configuring SSH server"
-	case PhaseDocker:
-		return "Installing Docker"
-	case PhaseSoftware:
-		return "Installing software"
-	case PhaseDesktop:
-		return "Installing desktop environment"
-	case PhaseDotfiles:
-		return "Installing dotfiles"
-	default:
-		return "Unknown phase"
+	if d, ok := p.lookup(); ok {
+		return d.Description
+	}
+	return "Unknown phase"
+}
+
+// Name returns the stable identifier of the phase.
+func (p Phase) Name() string {
+	return string(p)
+}
+
+// Weight returns the expected relative cost of the phase, used to apportion
+// the progress bar. Package transactions dominate the install time.
+func (p Phase) Weight() float64 {
+	d, _ := p.lookup()
+	return d.Weight
+}
+
+// renamed maps the former names of phases to the phases, so checkpoints,
+// hooks and --only-phases that use them keep working.
+var renamed = map[string]Phase{"btrfs": PhaseFilesystem}
+
+// PhaseByName returns the phase whose Name, or former name, is name.
+func PhaseByName(name string) (Phase, bool) {
+	if p, ok := renamed[name]; ok {
+		return p, true
+	}
+	d, ok := Phase(name).lookup()
+	return d.Phase, ok
+}
+
+// namedIn reports whether names includes p, by its name or a former one.
+func (p Phase) namedIn(names ...string) bool {
+	return slices.ContainsFunc(names, func(name string) bool {
+		q, ok := PhaseByName(name)
+		return ok && q == p
+	})
+}
+
+// CheckPhases reports the first name that is not a registered phase.
+func CheckPhases(names []string) error {
+	for _, name := range names {
+		if _, ok := PhaseByName(name); !ok {
+			var valid []string
+			for _, d := range registry {
+				valid = append(valid, d.Phase.Name())
+			}
+			return fmt.Errorf("unknown phase %q: must be one of %s", name, strings.Join(valid, ", "))
+		}
+	}
+	return nil
+}
+
+// phaseState is what Run does with a registered phase.
+type phaseState string
+
+const (
+	stateRun        phaseState = "run"
+	stateSkipped    phaseState = "skipped"      // its Skip predicate holds for the configuration
+	stateCheckpoint phaseState = "checkpoint"   // completed by the install being resumed
+	stateUnselected phaseState = "not selected" // left out by --only-phases
+)
+
+type plannedPhase struct {
+	PhaseDef
+	state phaseState
+}
+
+// plan decides what happens to each registered phase. Phases named by
+// OnlyPhases run even if the checkpoint records them as done. Every phase
+// that runs needs each of its dependencies skipped, completed earlier, or
+// run before it.
+func (inst *Installer) plan() ([]plannedPhase, error) {
+	only := inst.cfg.OnlyPhases
+	if err := CheckPhases(only); err != nil {
+		return nil, err
+	}
+	var plan []plannedPhase
+	states := map[Phase]phaseState{}
+	for _, d := range registry {
+		state := stateRun
+		switch {
+		case d.Skip != nil && d.Skip(inst.cfg):
+			state = stateSkipped
+		case len(only) > 0 && !d.Phase.namedIn(only...):
+			state = stateUnselected
+		case len(only) == 0 && inst.checkpoint.Done(d.Phase):
+			state = stateCheckpoint
+		}
+		if state == stateRun {
+			for _, dep := range d.Deps {
+				if states[dep] == stateUnselected && !inst.checkpoint.Done(dep) {
+					return nil, fmt.Errorf("phase %s needs %s: select it too, or --resume an install that completed it", d.Phase.Name(), dep.Name())
+				}
+			}
+		}
+		states[d.Phase] = state
+		plan = append(plan, plannedPhase{d, state})
 	}
+	return plan, nil
 }
 
 // PhaseUpdate is sent through the progress channel to report status to the TUI.
@@ -64,4 +314,7 @@ type PhaseUpdate struct {
 	LogLine     string
 	Done        bool
 	Err         error
+	Awaiting    bool            // Run is paused on the failure in Err until it gets a Decision
+	Skippable   bool            // the failed phase may be skipped
+	Prefetch    *PrefetchStatus // set on updates about the background package download
 }
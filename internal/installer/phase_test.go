@@ -0,0 +1,122 @@
+package installer
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	seen := map[Phase]bool{}
+	for _, d := range Phases() {
+		if seen[d.Phase] {
+			t.Errorf("phase %s registered twice", d.Phase)
+		}
+		if d.Description == "" || d.Weight <= 0 || d.run == nil {
+			t.Errorf("phase %s is incomplete: %+v", d.Phase, d)
+		}
+		for _, dep := range d.Deps {
+			if !seen[dep] {
+				t.Errorf("phase %s depends on %s, which does not run before it", d.Phase, dep)
+			}
+		}
+		seen[d.Phase] = true
+	}
+	if p, ok := PhaseByName("base_install"); !ok || p != PhaseBaseInstall || p.String() != "Installing base system" {
+		t.Errorf("PhaseByName(base_install) = %q, %v", p, ok)
+	}
+	// btrfs was the filesystem phase's name before it handled other filesystems
+	if p, ok := PhaseByName("btrfs"); !ok || p != PhaseFilesystem {
+		t.Errorf("PhaseByName(btrfs) = %q, %v, want %q", p, ok, PhaseFilesystem)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		only    []string
+		done    []string // phases completed by a previous run
+		run     []Phase
+		wantErr string
+	}{
+		{
+			name: "all",
+			run: []Phase{PhasePreflight, PhasePrepare, PhasePartition, PhaseFilesystem, PhaseBaseInstall,
+				PhaseSystemConfig, PhaseSwap, PhaseBootloader, PhaseServices, PhaseSoftware, PhaseVerify},
+		},
+		{
+			name: "resume",
+			done: []string{"preflight", "prepare", "partition", "btrfs", "base_install"},
+			run:  []Phase{PhaseSystemConfig, PhaseSwap, PhaseBootloader, PhaseServices, PhaseSoftware, PhaseVerify},
+		},
+		{
+			name: "only phases completed before",
+			only: []string{"software", "base_install"},
+			done: []string{"preflight", "prepare", "partition", "btrfs", "base_install", "system_config"},
+			run:  []Phase{PhaseBaseInstall, PhaseSoftware},
+		},
+		{
+			name:    "only phases missing a dependency",
+			only:    []string{"software"},
+			wantErr: "phase software needs system_config",
+		},
+		{
+			name:    "only phases unknown",
+			only:    []string{"kernel"},
+			wantErr: `unknown phase "kernel"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.OnlyPhases = tt.only
+			inst, _, _ := newTestInstaller(t, cfg)
+			inst.checkpoint.Completed = tt.done
+			plan, err := inst.plan()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("plan() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var run []Phase
+			for _, p := range plan {
+				if p.state == stateRun {
+					run = append(run, p.Phase)
+				}
+			}
+			if !slices.Equal(run, tt.run) {
+				t.Errorf("runs %v, want %v", run, tt.run)
+			}
+		})
+	}
+}
+
+func TestRunOnlyPhases(t *testing.T) {
+	cfg := testConfig()
+	first, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("arch-chroot /mnt systemctl enable NetworkManager", "", errFake)
+	first.Run(context.Background())
+
+	cfg.Resume = true
+	cfg.OnlyPhases = []string{"services", "software"}
+	inst, fake, progress := newTestInstaller(t, cfg)
+	inst.checkpointPath = first.checkpointPath
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; !last.Done {
+		t.Fatalf("last update = %+v, want Done", last)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{"mount", "systemctl enable NetworkManager", "git clone"},
+		[]string{"pacstrap", "grub-install", "systemctl is-enabled"},
+	)
+	if _, err := LoadCheckpoint(inst.checkpointPath); err != nil {
+		t.Errorf("checkpoint removed after a partial run: %v", err)
+	}
+}
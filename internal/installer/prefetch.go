@@ -0,0 +1,153 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// PrefetchDir holds the package database and cache of the background
+// download. It is on the live system's tmpfs, as the target is not mounted
+// while the download runs.
+const PrefetchDir = "/tmp/archy-prefetch"
+
+// PrefetchMinMemory is the memory that must be available for the background
+// download. The packages stay on tmpfs, in RAM, until pacstrap has installed
+// them, and a desktop package set is 2 GiB or more.
+const PrefetchMinMemory = 4 << 30
+
+// PrefetchCacheDir is where the background download leaves the packages for
+// pacstrap.
+const PrefetchCacheDir = PrefetchDir + "/pkg"
+
+// PrefetchStatus reports how far the background package download has got.
+type PrefetchStatus struct {
+	Downloaded int // packages fetched so far
+	Total      int // packages to fetch, 0 until pacman has resolved them
+	Done       bool
+	Err        error // why the download stopped early, once Done
+}
+
+// prefetch is a package download running alongside disk preparation.
+type prefetch struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the download has exited
+	err    error         // valid once done is closed
+}
+
+// startPrefetch starts downloading the package set in the background, so
+// the network is busy while the disk is partitioned, encrypted and
+// formatted. It writes the live mirror list first so the download uses it.
+// Nothing is fetched for an offline install, when base_install will not run
+// or with less than PrefetchMinMemory available, as pacstrap then downloads
+// to the target disk instead. A dry run skips it too, so the recorded plan
+// does not depend on when the download would have run.
+func (inst *Installer) startPrefetch(ctx context.Context) error {
+	if inst.prefetch != nil || inst.cfg.OfflineRepo != nil || inst.cfg.DryRun || !inst.willRun(PhaseBaseInstall) {
+		return nil
+	}
+	if avail := inst.probe.meminfo("MemAvailable"); avail < PrefetchMinMemory {
+		inst.log(fmt.Sprintf("Not downloading packages in the background: %s of memory available, need %d GiB",
+			config.FormatBytes(avail), PrefetchMinMemory>>30))
+		return nil
+	}
+	if err := inst.writeMirrorlist(ctx, inst.exec, "live system"); err != nil {
+		return err
+	}
+	for _, dir := range []string{PrefetchDir + "/db", PrefetchCacheDir} {
+		if err := inst.exec.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create %s: %w", dir, err)
+		}
+	}
+
+	pkgs := inst.packageSet(ctx)
+	pctx, cancel := context.WithCancel(ctx)
+	pf := &prefetch{cancel: cancel, done: make(chan struct{})}
+	inst.prefetch = pf
+	inst.log(fmt.Sprintf("Downloading %d packages in the background...", len(pkgs)))
+	go func() {
+		defer close(pf.done)
+		pf.err = inst.runPrefetch(pctx, pkgs)
+		inst.sendPrefetch(pctx, PrefetchStatus{Done: true, Err: pf.err})
+	}()
+	return nil
+}
+
+// runPrefetch downloads pkgs into PrefetchCacheDir. Its own empty package
+// database makes pacman resolve the transaction as pacstrap will, instead of
+// against the packages on the live system. Output goes to the log file only,
+// so it does not interleave with the running phase.
+func (inst *Installer) runPrefetch(ctx context.Context, pkgs []string) error {
+	args := append([]string{"-Syw", "--noconfirm", "--dbpath", PrefetchDir + "/db", "--cachedir", PrefetchCacheDir, "--"}, pkgs...)
+	inst.logToFile("RUN   pacman %v", args)
+	var p pacmanProgress
+	w := newLineWriter(func(line string) {
+		inst.logToFile("      prefetch: %s", inst.secrets.redact(line))
+		if p.update(line) {
+			inst.sendPrefetch(ctx, PrefetchStatus{Downloaded: min(p.downloaded, p.total), Total: p.total})
+		}
+	})
+	out, err := inst.exec.Run(ctx, Command{Name: "pacman", Args: args, Output: w})
+	w.Flush()
+	if err != nil {
+		inst.logToFile("FAIL  prefetch: %v", err)
+		return fmt.Errorf("pacman: %w: %s", err, firstLine(out))
+	}
+	inst.logToFile("OK    prefetch")
+	return nil
+}
+
+// sendPrefetch reports the download's progress, giving up if it has been
+// stopped so a finished install is not held up by a reader that has gone.
+func (inst *Installer) sendPrefetch(ctx context.Context, s PrefetchStatus) {
+	select {
+	case inst.progress <- PhaseUpdate{Prefetch: &s}:
+	case <-ctx.Done():
+	}
+}
+
+// awaitPrefetch waits for the background download and returns the pacstrap
+// options that make it use the downloaded packages. pacman downloads
+// whatever is missing into the first cache directory, so the target's cache
+// comes first and a failed prefetch only costs the time it took.
+func (inst *Installer) awaitPrefetch() []string {
+	pf := inst.prefetch
+	if pf == nil {
+		return nil
+	}
+	select {
+	case <-pf.done:
+	default:
+		inst.log("Waiting for the background package download to finish...")
+		<-pf.done
+	}
+	if pf.err != nil {
+		inst.log("Warning: background package download failed, pacstrap will fetch the rest: " + pf.err.Error())
+	}
+	return []string{"--cachedir", TargetRoot + "/var/cache/pacman/pkg", "--cachedir", PrefetchCacheDir}
+}
+
+// stopPrefetch cancels a download that is still running and waits for it.
+// A failed or cancelled install calls it before its last update, which must
+// not be followed by one from the download.
+func (inst *Installer) stopPrefetch() {
+	if pf := inst.prefetch; pf != nil {
+		pf.cancel()
+		<-pf.done
+	}
+}
+
+// willRun reports whether this install is going to run phase p: it is not
+// skipped by the configuration, not left out by --only-phases and not
+// already completed by the install being resumed.
+func (inst *Installer) willRun(p Phase) bool {
+	d, ok := p.lookup()
+	switch {
+	case !ok || d.Skip != nil && d.Skip(inst.cfg):
+		return false
+	case len(inst.cfg.OnlyPhases) > 0:
+		return p.namedIn(inst.cfg.OnlyPhases...)
+	}
+	return !inst.checkpoint.Done(p)
+}
@@ -0,0 +1,142 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// gatedExecutor holds back commands starting with gate until a command
+// starting with release has run, failing them if that takes too long.
+type gatedExecutor struct {
+	*fakeExecutor
+	gate, release string
+	once          sync.Once
+	open          chan struct{}
+}
+
+func (g *gatedExecutor) Run(ctx context.Context, c Command) ([]byte, error) {
+	line := c.String()
+	if strings.HasPrefix(line, g.gate) {
+		select {
+		case <-g.open:
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("gate never opened")
+		}
+	}
+	if strings.HasPrefix(line, g.release) {
+		g.once.Do(func() { close(g.open) })
+	}
+	return g.fakeExecutor.Run(ctx, c)
+}
+
+// prefetchStatuses returns the background download updates in updates.
+func prefetchStatuses(updates []PhaseUpdate) []PrefetchStatus {
+	var statuses []PrefetchStatus
+	for _, u := range updates {
+		if u.Prefetch != nil {
+			statuses = append(statuses, *u.Prefetch)
+		}
+	}
+	return statuses
+}
+
+func TestRunPrefetchesDuringDiskPreparation(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacman -Syw", pacstrapOutput, nil)
+	// The download cannot finish until the disk is formatted, so a
+	// prefetch that blocked the install would never get there
+	gated := &gatedExecutor{fakeExecutor: fake, gate: "pacman -Syw", release: "mkfs.btrfs", open: make(chan struct{})}
+	inst.exec = gated
+	inst.target = ChrootExecutor{Root: TargetRoot, Exec: gated}
+	inst.Run(context.Background())
+
+	assertCommands(t, fake.commands(),
+		[]string{
+			"mkfs.btrfs",
+			"pacman -Syw --noconfirm --dbpath /tmp/archy-prefetch/db --cachedir /tmp/archy-prefetch/pkg -- base linux",
+			"pacstrap /mnt --cachedir /mnt/var/cache/pacman/pkg --cachedir /tmp/archy-prefetch/pkg base linux",
+		},
+		nil,
+	)
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Fatalf("last update = %+v, want the install complete", last)
+	}
+	statuses := prefetchStatuses(updates)
+	if len(statuses) < 2 {
+		t.Fatalf("prefetch updates = %+v, want progress and completion", statuses)
+	}
+	if got := statuses[len(statuses)-2]; got.Downloaded != 2 || got.Total != 4 {
+		t.Errorf("last progress = %+v, want 2 of 4 downloaded", got)
+	}
+	if got := statuses[len(statuses)-1]; !got.Done || got.Err != nil {
+		t.Errorf("final status = %+v, want done without error", got)
+	}
+}
+
+func TestRunPrefetchFailureFallsBackToPacstrap(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacman -Syw", "error: failed retrieving file 'linux.pkg.tar.zst'", errFake)
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Fatalf("last update = %+v, want the install complete", last)
+	}
+	statuses := prefetchStatuses(updates)
+	if len(statuses) == 0 || statuses[len(statuses)-1].Err == nil {
+		t.Errorf("prefetch updates = %+v, want a failure", statuses)
+	}
+	warned := false
+	for _, u := range updates {
+		warned = warned || strings.HasPrefix(u.LogLine, "Warning: background package download failed")
+	}
+	if !warned {
+		t.Error("prefetch failure was not logged")
+	}
+	assertCommands(t, fake.commands(), []string{"pacman -Syw", "pacstrap /mnt --cachedir"}, nil)
+}
+
+func TestRunSkipsPrefetchWithoutBaseInstall(t *testing.T) {
+	cfg := testConfig()
+	cfg.OnlyPhases = []string{"preflight", "prepare", "partition", "btrfs"}
+	inst, fake, _ := newTestInstaller(t, cfg)
+	inst.Run(context.Background())
+
+	assertCommands(t, fake.commands(), []string{"timedatectl", "mkfs.btrfs"}, []string{"pacman -Syw", "pacstrap"})
+}
+
+func TestRunSkipsPrefetchInDryRun(t *testing.T) {
+	cfg := testConfig()
+	cfg.DryRun = true
+	inst, fake, _ := newTestInstaller(t, cfg)
+	inst.Run(context.Background())
+
+	assertCommands(t, fake.commands(), []string{"pacstrap /mnt base"}, []string{"pacman -Syw", "--cachedir"})
+}
+
+func TestRunSkipsPrefetchWithLittleMemory(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	inst.probe = fakeSystem(fstest.MapFS{
+		"proc/meminfo": {Data: []byte("MemTotal:        4026532 kB\nMemAvailable:    3145728 kB\n")},
+	})
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Fatalf("last update = %+v, want the install complete", last)
+	}
+	logged := false
+	for _, u := range updates {
+		logged = logged || u.LogLine == "Not downloading packages in the background: 3.0 GiB of memory available, need 4 GiB"
+	}
+	if !logged {
+		t.Error("skipped prefetch was not logged")
+	}
+	assertCommands(t, fake.commands(), []string{"pacstrap /mnt base"}, []string{"pacman -Syw", "--cachedir"})
+}
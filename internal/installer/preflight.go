@@ -0,0 +1,328 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// MinRootSize is the smallest root partition archy will install to, in bytes.
+const MinRootSize = 20 << 30
+
+// probe reads the state of the live system for the pre-flight checks. It
+// only ever reads, apart from refreshing pacman's sync databases outside dry
+// runs, so it is used even in dry runs; tests substitute a fake system.
+type probe struct {
+	readFile func(name string) ([]byte, error)
+	readDir  func(name string) ([]fs.DirEntry, error)
+	stat     func(name string) (fs.FileInfo, error)
+	lookPath func(file string) (string, error)
+	pacman   func(args ...string) ([]byte, error) // combined output
+	pid      int
+}
+
+var liveSystem = probe{
+	readFile: os.ReadFile,
+	readDir:  os.ReadDir,
+	stat:     os.Stat,
+	lookPath: exec.LookPath,
+	pacman: func(args ...string) ([]byte, error) {
+		return exec.Command("pacman", args...).CombinedOutput()
+	},
+	pid: os.Getpid(),
+}
+
+// Preflight checks that the live system and target disk are fit for cfg
+// before anything is modified. It returns one error per failed check.
+func Preflight(cfg *config.InstallConfig) []error {
+	return liveSystem.check(cfg)
+}
+
+func (p probe) check(cfg *config.InstallConfig) []error {
+	var errs []error
+	fail := func(format string, a ...any) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if _, err := p.stat("/sys/firmware/efi"); err != nil {
+		fail("not booted in UEFI mode (/sys/firmware/efi is missing)")
+	}
+
+	tools := append([]string{"sgdisk"}, mkfsTools(cfg)...)
+	tools = append(tools, "pacman", "pacstrap", "genfstab", "arch-chroot")
+	if cfg.Encrypt {
+		tools = append(tools, "cryptsetup")
+	}
+	if cfg.OfflineRepo != nil && cfg.OfflineRepo.Bundle != "" {
+		tools = append(tools, "bsdtar")
+	}
+	if len(cfg.MirrorCountries) > 0 {
+		tools = append(tools, "reflector")
+	}
+	for _, tool := range tools {
+		if _, err := p.lookPath(tool); err != nil {
+			fail("required tool %s not found", tool)
+		}
+	}
+
+	disk := cfg.Device.Path()
+	mounts, err := p.mounts()
+	if err != nil {
+		fail("read mounts: %v", err)
+	}
+	for _, m := range mounts {
+		// A resumed install cleans up its own mounts under /mnt first
+		if cfg.Resume && underTarget(m.Target) {
+			continue
+		}
+		switch {
+		case onDisk(m.Source, disk) && strings.HasPrefix(m.Target, "/run/archiso"):
+			fail("%s is the live boot medium", disk)
+		case onDisk(m.Source, disk):
+			fail("%s is mounted at %s", m.Source, m.Target)
+		case underTarget(m.Target):
+			fail("%s is already mounted at %s", m.Source, m.Target)
+		}
+	}
+
+	if !cfg.Resume {
+		entries, err := p.readDir(TargetRoot)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fail("read %s: %v", TargetRoot, err)
+		} else if len(entries) > 0 {
+			fail("%s is not empty", TargetRoot)
+		}
+	}
+
+	if err := p.checkSize(cfg); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.OfflineRepo != nil {
+		if err := p.checkOfflineRepo(cfg); err != nil {
+			errs = append(errs, err)
+		}
+	} else if _, err := p.lookPath("pacman"); err == nil {
+		errs = append(errs, p.checkPackages(requiredPackages(cfg), !cfg.DryRun)...)
+	}
+
+	if pid, ok := p.otherArchy(); ok {
+		fail("another archy is already running (pid %d)", pid)
+	}
+	return errs
+}
+
+// mounts returns the mount table of the live system.
+func (p probe) mounts() ([]MountPoint, error) {
+	data, err := p.readFile("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []MountPoint
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		mounts = append(mounts, MountPoint{Source: f[0], Target: f[1]})
+	}
+	return mounts, nil
+}
+
+// checkSize verifies the disk has room for the partition layout, with a root
+// partition of at least MinRootSize.
+func (p probe) checkSize(cfg *config.InstallConfig) error {
+	data, err := p.readFile(filepath.Join("/sys/block", cfg.Device.Name, "size"))
+	if err != nil {
+		return fmt.Errorf("read size of %s: %w", cfg.Device.Path(), err)
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("size of %s: %w", cfg.Device.Path(), err)
+	}
+	if cfg.Alongside {
+		// A resumed install has already taken the free space for root
+		if cfg.Resume {
+			return nil
+		}
+		return checkFreeSpace(cfg)
+	}
+	var need int64
+	for _, p := range cfg.PartitionLayout() {
+		if p.Size == "" {
+			if p.IsRoot() {
+				need += MinRootSize
+			}
+			continue
+		}
+		size, err := sizeBytes(p.Size)
+		if err != nil {
+			return fmt.Errorf("%s partition: %w", p.Name(), err)
+		}
+		if p.IsRoot() && size < MinRootSize {
+			return fmt.Errorf("root partition is %s, need at least %d GiB", p.Size, MinRootSize>>30)
+		}
+		need += size
+	}
+	if size := sectors * 512; size < need {
+		return fmt.Errorf("%s is too small: %d MiB, the partition layout needs %d MiB including %d GiB root",
+			cfg.Device.Path(), size>>20, need>>20, MinRootSize>>30)
+	}
+	return nil
+}
+
+// checkFreeSpace verifies that a disk keeping its partitions has an EFI
+// system partition to reuse and room for a root partition of at least
+// MinRootSize.
+func checkFreeSpace(cfg *config.InstallConfig) error {
+	table := cfg.Device.Table
+	if err := table.CheckAlongside(); err != nil {
+		return fmt.Errorf("%s: %w", cfg.Device.Path(), err)
+	}
+	free, _ := table.LargestFree()
+	if size := table.Bytes(free.Sectors); size < MinRootSize {
+		return fmt.Errorf("%s has %s of unallocated space, need %d GiB for root: shrink another partition first",
+			cfg.Device.Path(), config.FormatBytes(size), MinRootSize>>30)
+	}
+	return nil
+}
+
+// meminfo returns the /proc/meminfo entry key in bytes, or 0 if it cannot be
+// read.
+func (p probe) meminfo(key string) int64 {
+	data, err := p.readFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, key+":"); ok {
+			kb, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb << 10
+		}
+	}
+	return 0
+}
+
+// otherArchy returns the pid of another running archy process, if any.
+func (p probe) otherArchy() (int, bool) {
+	entries, err := p.readDir("/proc")
+	if err != nil {
+		return 0, false
+	}
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == p.pid {
+			continue
+		}
+		comm, err := p.readFile(filepath.Join("/proc", e.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(comm)) == "archy" {
+			return pid, true
+		}
+	}
+	return 0, false
+}
+
+// checkOfflineRepo verifies the offline repository can supply every package
+// the install needs, now that the wizard has settled the desktop, shell and
+// optional services.
+func (p probe) checkOfflineRepo(cfg *config.InstallConfig) error {
+	ix, err := p.offlineIndex(cfg)
+	if err != nil {
+		return err
+	}
+	if missing := ix.Missing(requiredPackages(cfg)); len(missing) > 0 {
+		return fmt.Errorf("offline repository %s lacks %s", cfg.OfflineRepo.Dir, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// offlineIndex reads the database of the offline repository, from the
+// bundle or the probed system.
+func (p probe) offlineIndex(cfg *config.InstallConfig) (*config.RepoIndex, error) {
+	repo := cfg.OfflineRepo
+	var fsys fs.FS = probeFS{p, repo.Dir}
+	if repo.Bundle != "" {
+		var err error
+		if fsys, err = repo.FS(cfg.BundleFS); err != nil {
+			return nil, err
+		}
+	}
+	return repo.ReadIndex(fsys)
+}
+
+// probeFS is the directory dir of the probed system as a file system.
+type probeFS struct {
+	p   probe
+	dir string
+}
+
+func (f probeFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+}
+
+func (f probeFS) ReadFile(name string) ([]byte, error) {
+	return f.p.readFile(filepath.Join(f.dir, name))
+}
+
+func (f probeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.p.readDir(filepath.Join(f.dir, name))
+}
+
+// onDisk reports whether source is disk or one of its partitions.
+func onDisk(source, disk string) bool {
+	rest, ok := strings.CutPrefix(source, disk)
+	if !ok {
+		return false
+	}
+	rest = strings.TrimPrefix(rest, "p")
+	if rest == "" {
+		return true
+	}
+	_, err := strconv.Atoi(rest)
+	return err == nil
+}
+
+func underTarget(path string) bool {
+	return path == TargetRoot || strings.HasPrefix(path, TargetRoot+"/")
+}
+
+// sizeBytes converts a partition size such as "512M" or "1G" to bytes.
+func sizeBytes(s string) (int64, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	switch s[len(s)-1] {
+	case 'M', 'm':
+		return n << 20, nil
+	case 'G', 'g':
+		return n << 30, nil
+	default:
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+}
+
+// preflight fails the install before the disk is touched if any pre-flight
+// check fails.
+func (inst *Installer) preflight(ctx context.Context) error {
+	errs := inst.probe.check(inst.cfg)
+	for _, err := range errs {
+		inst.log("Pre-flight check failed: " + err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d pre-flight check(s) failed: %w", len(errs), errors.Join(errs...))
+	}
+	inst.log("All pre-flight checks passed")
+	return nil
+}
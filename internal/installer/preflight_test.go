@@ -0,0 +1,196 @@
+package installer
+
+import (
+	"context"
+	"io/fs"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// fakeSystem returns a probe that reads from a healthy live system for
+// testConfig: UEFI, a 100G /dev/sda, nothing mounted and an empty /mnt.
+// Tools listed in missing are not found.
+func fakeSystem(files fstest.MapFS, missing ...string) probe {
+	sys := fstest.MapFS{
+		"sys/firmware/efi":   {Mode: fs.ModeDir},
+		"sys/block/sda/size": {Data: []byte("209715200\n")},
+		"proc/mounts":        {Data: []byte("proc /proc proc rw 0 0\nairootfs / overlay rw 0 0\n")},
+		"proc/1/comm":        {Data: []byte("systemd\n")},
+		"proc/42/comm":       {Data: []byte("archy\n")},
+		"proc/meminfo":       {Data: []byte("MemTotal:       16327040 kB\nMemAvailable:   14123456 kB\n")},
+		"mnt":                {Mode: fs.ModeDir},
+	}
+	for name, f := range files {
+		sys[name] = f
+	}
+	rel := func(name string) string { return strings.TrimPrefix(name, "/") }
+	return probe{
+		readFile: func(name string) ([]byte, error) { return fs.ReadFile(sys, rel(name)) },
+		readDir:  func(name string) ([]fs.DirEntry, error) { return fs.ReadDir(sys, rel(name)) },
+		stat:     func(name string) (fs.FileInfo, error) { return fs.Stat(sys, rel(name)) },
+		lookPath: func(file string) (string, error) {
+			if slices.Contains(missing, file) {
+				return "", exec.ErrNotFound
+			}
+			return "/usr/bin/" + file, nil
+		},
+		pacman: func(args ...string) ([]byte, error) { return nil, nil },
+		pid:    42,
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		missing []string
+		encrypt bool
+		resume  bool
+		parts   []config.Partition
+		want    []string // substrings of the expected failures, in order
+	}{
+		{name: "healthy"},
+		{
+			name:  "bios boot",
+			files: fstest.MapFS{"sys/firmware/efi": nil},
+			want:  []string{"UEFI"},
+		},
+		{
+			name:    "missing tools",
+			missing: []string{"pacstrap", "cryptsetup"},
+			encrypt: true,
+			want:    []string{"pacstrap", "cryptsetup"},
+		},
+		{
+			name:    "cryptsetup only needed for encryption",
+			missing: []string{"cryptsetup"},
+		},
+		{
+			name:  "disk mounted",
+			files: fstest.MapFS{"proc/mounts": {Data: []byte("/dev/sda2 /home ext4 rw 0 0\n/dev/sdb1 /media ext4 rw 0 0\n")}},
+			want:  []string{"/dev/sda2 is mounted at /home"},
+		},
+		{
+			name:  "live boot medium",
+			files: fstest.MapFS{"proc/mounts": {Data: []byte("/dev/sda1 /run/archiso/bootmnt iso9660 ro 0 0\n")}},
+			want:  []string{"live boot medium"},
+		},
+		{
+			name:  "target in use",
+			files: fstest.MapFS{"proc/mounts": {Data: []byte("/dev/sdb1 /mnt ext4 rw 0 0\n")}, "mnt/data": {}},
+			want:  []string{"already mounted at /mnt", "/mnt is not empty"},
+		},
+		{
+			name:   "resume ignores its own mounts",
+			files:  fstest.MapFS{"proc/mounts": {Data: []byte("/dev/sda2 /mnt btrfs rw 0 0\n")}, "mnt/etc": {Mode: fs.ModeDir}},
+			resume: true,
+		},
+		{
+			name:  "disk too small",
+			files: fstest.MapFS{"sys/block/sda/size": {Data: []byte("16777216\n")}},
+			want:  []string{"too small: 8192 MiB"},
+		},
+		{
+			name: "layout larger than the disk",
+			parts: []config.Partition{
+				{Size: "1G", Type: "efi", Mountpoint: "/boot"},
+				{Size: "90G", Type: "linux", Mountpoint: "/"},
+				{Size: "20G", Type: "linux", Filesystem: "xfs", Mountpoint: "/data"},
+			},
+			want: []string{"too small: 102400 MiB, the partition layout needs 113664 MiB"},
+		},
+		{
+			name:  "root partition too small",
+			parts: []config.Partition{{Size: "1G", Type: "efi", Mountpoint: "/boot"}, {Size: "10G", Type: "linux", Mountpoint: "/"}},
+			want:  []string{"root partition is 10G"},
+		},
+		{
+			name:    "layout needs its mkfs tools",
+			missing: []string{"mkfs.xfs", "mkswap"},
+			parts: []config.Partition{
+				{Size: "1G", Type: "efi", Mountpoint: "/boot"},
+				{Size: "4G", Type: "swap", Filesystem: "swap"},
+				{Size: "30G", Type: "linux", Mountpoint: "/"},
+				{Type: "linux", Filesystem: "xfs", Mountpoint: "/data"},
+			},
+			want: []string{"mkswap", "mkfs.xfs"},
+		},
+		{
+			name:  "another archy running",
+			files: fstest.MapFS{"proc/77/comm": {Data: []byte("archy\n")}},
+			want:  []string{"pid 77"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Encrypt = tt.encrypt
+			cfg.Resume = tt.resume
+			cfg.Partitions = tt.parts
+			errs := fakeSystem(tt.files, tt.missing...).check(cfg)
+			if len(errs) != len(tt.want) {
+				t.Fatalf("check() = %v, want %d failure(s)", errs, len(tt.want))
+			}
+			for i, err := range errs {
+				if !strings.Contains(err.Error(), tt.want[i]) {
+					t.Errorf("failure %d = %q, want %q", i, err, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPreflightAlongside(t *testing.T) {
+	cfg := testConfig()
+	cfg.Device.Table = windowsDisk()
+	cfg.Alongside = true
+	if errs := fakeSystem(nil).check(cfg); len(errs) > 0 {
+		t.Errorf("check() = %v, want no failures", errs)
+	}
+
+	// Windows has grown to leave 10 GiB
+	cfg.Device.Table.Partitions[1].Sectors += 40 << 21
+	errs := fakeSystem(nil).check(cfg)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "unallocated space") {
+		t.Errorf("check() = %v, want too little unallocated space", errs)
+	}
+}
+
+func TestOnDisk(t *testing.T) {
+	tests := []struct {
+		source, disk string
+		want         bool
+	}{
+		{"/dev/sda", "/dev/sda", true},
+		{"/dev/sda2", "/dev/sda", true},
+		{"/dev/sdab1", "/dev/sda", false},
+		{"/dev/nvme0n1p1", "/dev/nvme0n1", true},
+		{"/dev/nvme0n10", "/dev/nvme0n1", true},
+		{"/dev/mapper/cryptroot", "/dev/sda", false},
+	}
+	for _, tt := range tests {
+		if got := onDisk(tt.source, tt.disk); got != tt.want {
+			t.Errorf("onDisk(%q, %q) = %v, want %v", tt.source, tt.disk, got, tt.want)
+		}
+	}
+}
+
+func TestRunStopsAtFailedPreflight(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	inst.probe = fakeSystem(nil, "sgdisk")
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	last := updates[len(updates)-1]
+	if last.Err == nil || last.Phase != PhasePreflight {
+		t.Errorf("last update = %+v, want error in %s", last, PhasePreflight)
+	}
+	if cmds := fake.commands(); len(cmds) != 0 {
+		t.Errorf("ran %v, want nothing", cmds)
+	}
+}
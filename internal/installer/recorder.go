@@ -0,0 +1,69 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// Recorder is an Executor that changes nothing. It records every command and
+// file write in order so the full install plan can be reviewed.
+type Recorder struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Run(ctx context.Context, c Command) ([]byte, error) {
+	entry := "$ " + c.String()
+	if c.Stdin != "" {
+		entry += " < [stdin redacted]"
+	}
+	r.record(entry)
+	return nil, nil
+}
+
+func (r *Recorder) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "write %s (%04o):", name, perm)
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		b.WriteString("\n    " + line)
+	}
+	r.record(b.String())
+	return nil
+}
+
+func (r *Recorder) MkdirAll(path string, perm fs.FileMode) error {
+	r.record(fmt.Sprintf("mkdir -p -m %04o %s", perm, path))
+	return nil
+}
+
+func (r *Recorder) record(entry string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// WritePlan writes the recorded actions to w, one per line, in the order the
+// installer performed them.
+func (r *Recorder) WritePlan(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.entries) == 0 {
+		_, err := fmt.Fprintln(w, "# no install plan recorded (the wizard was not completed)")
+		return err
+	}
+	for _, e := range r.entries {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
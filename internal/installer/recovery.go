@@ -0,0 +1,61 @@
+package installer
+
+import (
+	"context"
+)
+
+// Decision is what the user wants done after a phase fails.
+type Decision int
+
+const (
+	DecisionAbort Decision = iota // clean up mounts and stop
+	DecisionRetry                 // run the failed phase again
+	DecisionSkip                  // carry on without the failed phase; refused for critical phases
+)
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionRetry:
+		return "retry"
+	case DecisionSkip:
+		return "skip"
+	default:
+		return "abort"
+	}
+}
+
+// AwaitDecisions makes Run pause after a failed phase until a Decision
+// arrives on ch, instead of stopping at the first failure.
+func (inst *Installer) AwaitDecisions(ch <-chan Decision) {
+	inst.decisions = ch
+}
+
+// decide reports the failure of p and waits for the user to decide what to
+// do about it. Without a decision channel every failure aborts. Cancelling
+// ctx while waiting also aborts.
+func (inst *Installer) decide(ctx context.Context, p plannedPhase, err error) Decision {
+	if inst.decisions == nil {
+		return DecisionAbort
+	}
+	inst.progress <- PhaseUpdate{
+		Phase:       p.Phase,
+		Description: p.Description,
+		Percent:     inst.phaseStart,
+		Err:         inst.secrets.redactErr(err),
+		Awaiting:    true,
+		Skippable:   !p.Critical,
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return DecisionAbort
+		case d := <-inst.decisions:
+			if d == DecisionSkip && p.Critical {
+				inst.logToFile("WARN  %s is critical and cannot be skipped", p.Phase)
+				continue
+			}
+			inst.logToFile("DECIDE %s: %s", p.Phase, d)
+			return d
+		}
+	}
+}
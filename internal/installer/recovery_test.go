@@ -0,0 +1,181 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// runDeciding runs inst to completion, answering each failure it pauses on
+// with decide. It returns every update Run sent.
+func runDeciding(inst *Installer, progress chan PhaseUpdate, decide func(PhaseUpdate) Decision) []PhaseUpdate {
+	decisions := make(chan Decision)
+	inst.AwaitDecisions(decisions)
+	go func() {
+		inst.Run(context.Background())
+		close(progress)
+	}()
+
+	var updates []PhaseUpdate
+	for u := range progress {
+		updates = append(updates, u)
+		if u.Awaiting {
+			decisions <- decide(u)
+		}
+	}
+	return updates
+}
+
+func countPrefix(lines []string, prefix string) int {
+	n := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRunRetriesFailedPhase(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacstrap", "error: failed retrieving file", errFake)
+	updates := runDeciding(inst, progress, func(u PhaseUpdate) Decision {
+		if u.Phase != PhaseBaseInstall || u.Skippable {
+			t.Errorf("paused on %+v, want unskippable %s", u, PhaseBaseInstall)
+		}
+		fake.respond("pacstrap", "", nil)
+		return DecisionRetry
+	})
+
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Errorf("last update = %+v, want Done after retry", last)
+	}
+	if n := countPrefix(fake.commands(), "pacstrap"); n != 2 {
+		t.Errorf("pacstrap ran %d times, want 2", n)
+	}
+}
+
+func TestRunRetriesPartlyMountedFilesystem(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	cfg.LUKSPassphrase = "correct horse"
+	inst, fake, progress := newTestInstaller(t, cfg)
+	fake.respond("bash -c 'genfstab", "", errFake)
+	var retried int
+	updates := runDeciding(inst, progress, func(u PhaseUpdate) Decision {
+		if u.Phase != PhaseFilesystem {
+			t.Errorf("paused on %+v, want %s", u, PhaseFilesystem)
+		}
+		// The subvolumes and mounts of the first attempt are still there
+		fake.respond("btrfs subvolume list /mnt", "ID 256 gen 7 top level 5 path @\nID 257 gen 7 top level 5 path @home\n", nil)
+		fake.respond("bash -c 'genfstab", "", nil)
+		retried = len(fake.commands())
+		return DecisionRetry
+	})
+
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Fatalf("last update = %+v, want Done after retry", last)
+	}
+	retry := fake.commands()[retried:]
+	assertCommands(t, retry,
+		[]string{
+			"umount -l /mnt/boot",
+			"umount -l /mnt",
+			"cryptsetup close cryptroot",
+			"cryptsetup open /dev/sda2 cryptroot",
+			"mount /dev/mapper/cryptroot /mnt",
+			"btrfs subvolume create /mnt/@snapshots",
+			"mount -o noatime,compress=zstd,subvol=@ /dev/mapper/cryptroot /mnt",
+			"genfstab -U /mnt > /mnt/etc/fstab",
+		},
+		[]string{"luksFormat", "mkfs", "btrfs subvolume create /mnt/@home", ">>"},
+	)
+	if n := countPrefix(retry, "btrfs subvolume create /mnt/@ "); n != 0 {
+		t.Errorf("retry created @ %d times, want it kept", n)
+	}
+}
+
+func TestRunRetriesPartlyConfiguredSystem(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("arch-chroot /mnt usermod", "usermod: group 'storage' does not exist", errFake)
+	updates := runDeciding(inst, progress, func(u PhaseUpdate) Decision {
+		// useradd got through before usermod failed
+		fake.respond("arch-chroot /mnt useradd", "useradd: user 'alice' already exists", errFake)
+		fake.respond("arch-chroot /mnt usermod", "", nil)
+		return DecisionRetry
+	})
+
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Fatalf("last update = %+v, want Done after retry", last)
+	}
+	if n := countPrefix(fake.commands(), "arch-chroot /mnt usermod"); n != 2 {
+		t.Errorf("usermod ran %d times, want 2", n)
+	}
+	if n := countPrefix(fake.commands(), "umount -l"); n != 0 {
+		t.Errorf("retrying %s unmounted the target", PhaseSystemConfig)
+	}
+}
+
+func TestRunSkipsFailedPhase(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("arch-chroot /mnt systemctl enable NetworkManager", "", errFake)
+	updates := runDeciding(inst, progress, func(u PhaseUpdate) Decision {
+		if u.Phase != PhaseServices || !u.Skippable {
+			t.Errorf("paused on %+v, want skippable %s", u, PhaseServices)
+		}
+		return DecisionSkip
+	})
+
+	if last := updates[len(updates)-1]; !last.Done || last.Err != nil {
+		t.Errorf("last update = %+v, want Done after skip", last)
+	}
+	r, _ := readReport(t, fake, inst.reportPath)
+	for _, p := range r.Phases {
+		if p.Name == "services" && p.Status != "skipped after failure" {
+			t.Errorf("services status = %q, want skipped after failure", p.Status)
+		}
+	}
+}
+
+func TestRunRefusesToSkipCriticalPhase(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacstrap", "", errFake)
+	decisions := []Decision{DecisionSkip, DecisionAbort}
+	inst.AwaitDecisions(func() <-chan Decision {
+		ch := make(chan Decision, len(decisions))
+		for _, d := range decisions {
+			ch <- d
+		}
+		return ch
+	}())
+	inst.Run(context.Background())
+
+	updates := drain(progress)
+	last := updates[len(updates)-1]
+	if last.Err == nil || last.Awaiting || last.Phase != PhaseBaseInstall {
+		t.Errorf("last update = %+v, want final failure in %s", last, PhaseBaseInstall)
+	}
+	assertCommands(t, fake.commands(), nil, []string{"arch-chroot"})
+}
+
+func TestRunAbortCleansUp(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	cfg.LUKSPassphrase = "secret"
+	inst, fake, progress := newTestInstaller(t, cfg)
+	fake.respond("pacstrap", "", errFake)
+	updates := runDeciding(inst, progress, func(PhaseUpdate) Decision { return DecisionAbort })
+
+	last := updates[len(updates)-1]
+	if last.Err == nil || last.Awaiting || last.Done {
+		t.Errorf("last update = %+v, want final failure", last)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{"umount -l /mnt", "cryptsetup close cryptroot"},
+		[]string{"arch-chroot"},
+	)
+	r, _ := readReport(t, fake, inst.reportPath)
+	if r.Status != "failed" {
+		t.Errorf("report status = %q, want failed", r.Status)
+	}
+}
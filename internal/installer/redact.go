@@ -0,0 +1,52 @@
+package installer
+
+import (
+	"sort"
+	"strings"
+)
+
+const redacted = "********"
+
+// redactor masks registered secrets (passwords, the LUKS passphrase) in text
+// bound for any log sink.
+type redactor struct {
+	secrets []string
+}
+
+// add registers a secret. Empty strings are ignored.
+func (r *redactor) add(secret string) {
+	if secret == "" {
+		return
+	}
+	r.secrets = append(r.secrets, secret)
+	// Longest first, so a secret containing another is masked whole
+	sort.Slice(r.secrets, func(i, j int) bool { return len(r.secrets[i]) > len(r.secrets[j]) })
+}
+
+func (r *redactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// redactedError masks secrets in an error's message while keeping it
+// unwrappable.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+func (r *redactor) redactErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
@@ -0,0 +1,80 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	var r redactor
+	r.add("")
+	r.add("hunter2")
+	r.add("hunter2hunter2")
+
+	got := r.redact("pw=hunter2hunter2 again=hunter2 empty=")
+	want := "pw=******** again=******** empty="
+	if got != want {
+		t.Errorf("redact() = %q, want %q", got, want)
+	}
+
+	base := errors.New("chpasswd: bad password hunter2")
+	err := r.redactErr(base)
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("redactErr() = %q, secret not masked", err)
+	}
+	if !errors.Is(err, base) {
+		t.Error("redactErr() lost the wrapped error")
+	}
+}
+
+func TestSecretsNeverLogged(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	cfg.RootPassword = "r00t-s3cret"
+	cfg.UserPassword = "alice-s3cret"
+	cfg.LUKSPassphrase = "luks-s3cret-phrase"
+	inst, fake, progress := newTestInstaller(t, cfg)
+	// A misbehaving tool that echoes what it was given
+	fake.respond("arch-chroot /mnt chpasswd", "chpasswd: echo alice-s3cret\n", nil)
+	fake.respond("arch-chroot /mnt grub-install", "cannot read r00t-s3cret\n", errFake)
+	inst.Run(context.Background())
+
+	secrets := []string{cfg.RootPassword, cfg.UserPassword, cfg.LUKSPassphrase}
+	var sinks []string
+	for _, u := range drain(progress) {
+		sinks = append(sinks, u.LogLine)
+		if u.Err != nil {
+			sinks = append(sinks, u.Err.Error())
+		}
+	}
+	sinks = append(sinks, fake.commands()...)
+	log, err := os.ReadFile(inst.logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks = append(sinks, string(log))
+
+	for _, s := range sinks {
+		for _, secret := range secrets {
+			if strings.Contains(s, secret) {
+				t.Errorf("secret %q leaked: %q", secret, s)
+			}
+		}
+	}
+
+	var chpasswd int
+	for _, c := range fake.cmds {
+		if strings.HasPrefix(c.String(), "arch-chroot /mnt chpasswd") {
+			chpasswd++
+			if !strings.Contains(c.Stdin, "-s3cret") {
+				t.Errorf("chpasswd stdin = %q, want user:password", c.Stdin)
+			}
+		}
+	}
+	if chpasswd != 2 {
+		t.Errorf("chpasswd ran %d times, want 2", chpasswd)
+	}
+}
@@ -0,0 +1,226 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+// Version is the archy version recorded in install reports. The main package
+// sets it from its build version.
+var Version = "dev"
+
+// ReportPath is where the install report is written, on the live system and
+// in the installed one.
+const ReportPath = "/root/archy-report.json"
+
+// Report is a machine-readable record of an install, for inventory tooling.
+type Report struct {
+	Version           string        `json:"version"`
+	Status            string        `json:"status"` // "complete", "failed" or "cancelled"
+	Error             string        `json:"error,omitempty"`
+	Started           time.Time     `json:"started"`
+	Finished          time.Time     `json:"finished"`
+	Config            ReportConfig  `json:"config"`
+	Phases            []PhaseReport `json:"phases"`
+	Packages          []Package     `json:"packages,omitempty"` // everything installed in the target
+	FailedAURPackages []string      `json:"failed_aur_packages,omitempty"`
+	Hardware          Hardware      `json:"hardware"`
+}
+
+// ReportConfig is the install configuration with secrets masked. Field names
+// follow archy.toml.
+type ReportConfig struct {
+	Device          string             `json:"device"`
+	EFISize         string             `json:"efi_size"`
+	Encrypt         bool               `json:"encrypt"`
+	LUKSPassphrase  string             `json:"luks_passphrase,omitempty"`
+	Hostname        string             `json:"hostname"`
+	Timezone        string             `json:"timezone"`
+	Username        string             `json:"username"`
+	UserPassword    string             `json:"user_password,omitempty"`
+	RootPassword    string             `json:"root_password,omitempty"`
+	ZRAMSize        string             `json:"zram_size"`
+	Desktop         string             `json:"desktop"`
+	Shell           string             `json:"shell"`
+	SSHD            bool               `json:"sshd"`
+	SSHPubKey       string             `json:"ssh_pubkey,omitempty"`
+	Docker          bool               `json:"docker"`
+	DockerGroup     bool               `json:"docker_group"`
+	Packages        []string           `json:"packages,omitempty"`
+	AURPackages     []string           `json:"aur_packages,omitempty"`
+	Dotfiles        []config.Dotfile   `json:"dotfiles,omitempty"`
+	OfflineRepo     string             `json:"offline_repo,omitempty"`
+	Mirrors         []string           `json:"mirrors,omitempty"`
+	MirrorCountries []string           `json:"mirror_countries,omitempty"`
+	Partitions      []config.Partition `json:"partitions"`
+	Alongside       bool               `json:"alongside,omitempty"`
+	Filesystem      string             `json:"filesystem"`
+	Subvolumes      []config.Subvolume `json:"subvolumes"`
+	Mount           string             `json:"mount_options"` // resolved from mount, shared by every subvolume
+	Resume          bool               `json:"resume,omitempty"`
+	DryRun          bool               `json:"dry_run,omitempty"`
+}
+
+// PhaseReport records how one phase went.
+type PhaseReport struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"` // "ok", "failed", "cancelled", "skipped", "skipped after failure" or "checkpoint"
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// Package is an installed package and its version.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Hardware describes the machine archy ran on.
+type Hardware struct {
+	Vendor         string `json:"vendor,omitempty"`
+	Product        string `json:"product,omitempty"`
+	CPU            string `json:"cpu,omitempty"`
+	MemoryBytes    int64  `json:"memory_bytes,omitempty"`
+	Virtualization string `json:"virtualization,omitempty"` // systemd-detect-virt, "none" on bare metal
+	Disk           string `json:"disk"`
+	DiskSize       string `json:"disk_size"`
+	DiskModel      string `json:"disk_model,omitempty"`
+	DiskKind       string `json:"disk_kind"` // "NVMe", "SSD" or "HDD"
+}
+
+func newReport(cfg *config.InstallConfig) *Report {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "********"
+	}
+	var offline string
+	if cfg.OfflineRepo != nil {
+		offline = cfg.OfflineRepo.Dir
+	}
+	shell := cfg.Shell
+	if shell == "" {
+		shell = "bash"
+	}
+	return &Report{
+		Version: Version,
+		Started: time.Now(),
+		Config: ReportConfig{
+			Device:          cfg.Device.Path(),
+			EFISize:         cfg.EFISize,
+			Encrypt:         cfg.Encrypt,
+			LUKSPassphrase:  mask(cfg.LUKSPassphrase),
+			Hostname:        cfg.Hostname,
+			Timezone:        cfg.Timezone,
+			Username:        cfg.Username,
+			UserPassword:    mask(cfg.UserPassword),
+			RootPassword:    mask(cfg.RootPassword),
+			ZRAMSize:        cfg.ZRAMSize,
+			Desktop:         cfg.Desktop.String(),
+			Shell:           shell,
+			SSHD:            cfg.SSHD,
+			SSHPubKey:       cfg.SSHPubKey,
+			Docker:          cfg.Docker,
+			DockerGroup:     cfg.DockerGroup,
+			Packages:        cfg.Packages,
+			AURPackages:     cfg.AURPackages,
+			Dotfiles:        cfg.Dotfiles,
+			OfflineRepo:     offline,
+			Mirrors:         cfg.Mirrors,
+			MirrorCountries: cfg.MirrorCountries,
+			Partitions:      cfg.PartitionLayout(),
+			Alongside:       cfg.Alongside,
+			Filesystem:      cfg.Filesystem.String(),
+			Subvolumes:      cfg.BtrfsSubvolumes(),
+			Mount:           cfg.RootMountOptions(),
+			Resume:          cfg.Resume,
+			DryRun:          cfg.DryRun,
+		},
+		Hardware: Hardware{
+			Disk:      cfg.Device.Path(),
+			DiskSize:  cfg.Device.Size,
+			DiskModel: cfg.Device.Model,
+			DiskKind:  cfg.Device.Kind(),
+		},
+	}
+}
+
+// phaseDone records a phase that did not run in this install.
+func (r *Report) phaseDone(p Phase, status string) {
+	r.Phases = append(r.Phases, PhaseReport{Name: p.Name(), Status: status})
+}
+
+// phaseRan records a phase that ran from started until now.
+func (r *Report) phaseRan(p Phase, status string, started time.Time) {
+	finished := time.Now()
+	r.Phases = append(r.Phases, PhaseReport{Name: p.Name(), Status: status, Started: &started, Finished: &finished})
+}
+
+// writeReport finishes the report with status and writes it to the live
+// system through the executor, so a dry run only records it. A report that
+// cannot be written is logged rather than failing the install.
+func (inst *Installer) writeReport(ctx context.Context, status string, err error) {
+	r := inst.report
+	r.Status = status
+	if err != nil {
+		r.Error = inst.secrets.redactErr(err).Error()
+	}
+	r.Finished = time.Now()
+	inst.detectHardware()
+	if status == "complete" {
+		r.Hardware.Virtualization = inst.virtualization(ctx)
+		r.Packages = inst.installedPackages(ctx)
+	}
+
+	data, jerr := json.MarshalIndent(r, "", "  ")
+	if jerr == nil {
+		jerr = inst.exec.WriteFile(inst.reportPath, append(data, '\n'), 0o600)
+	}
+	if jerr != nil {
+		inst.logToFile("WARN  report: %v", jerr)
+	}
+}
+
+// detectHardware fills in the hardware section from the live system without
+// running any commands, so it is safe after a failure or cancellation.
+func (inst *Installer) detectHardware() {
+	hw := &inst.report.Hardware
+	read := func(name string) string {
+		data, err := inst.probe.readFile(name)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	hw.Vendor = read("/sys/class/dmi/id/sys_vendor")
+	hw.Product = read("/sys/class/dmi/id/product_name")
+	for _, line := range strings.Split(read("/proc/cpuinfo"), "\n") {
+		if v, ok := strings.CutPrefix(line, "model name"); ok {
+			hw.CPU = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(v), ":"))
+			break
+		}
+	}
+	hw.MemoryBytes = inst.probe.meminfo("MemTotal")
+	hw.Virtualization = inst.virt
+}
+
+// installedPackages lists every package installed in the target.
+func (inst *Installer) installedPackages(ctx context.Context) []Package {
+	out, err := inst.target.Run(ctx, Command{Name: "pacman", Args: []string{"-Q"}})
+	if err != nil {
+		inst.logToFile("WARN  report: pacman -Q: %v", err)
+		return nil
+	}
+	var pkgs []Package
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, version, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			pkgs = append(pkgs, Package{Name: name, Version: version})
+		}
+	}
+	return pkgs
+}
@@ -0,0 +1,99 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func readReport(t *testing.T, fake *fakeExecutor, path string) (*Report, string) {
+	t.Helper()
+	data, ok := fake.files[path]
+	if !ok {
+		t.Fatalf("no report written to %s", path)
+	}
+	var r Report
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	return &r, string(data)
+}
+
+func TestRunWritesReport(t *testing.T) {
+	cfg := testConfig()
+	cfg.RootPassword = "hunter2-root"
+	cfg.AURPackages = []string{"paru-bin", "broken-pkg"}
+	inst, fake, _ := newTestInstaller(t, cfg)
+	inst.probe = fakeSystem(fstest.MapFS{
+		"proc/cpuinfo":                  {Data: []byte("processor\t: 0\nmodel name\t: AMD EPYC 7763\n")},
+		"proc/meminfo":                  {Data: []byte("MemTotal:        8048576 kB\n")},
+		"sys/class/dmi/id/sys_vendor":   {Data: []byte("QEMU\n")},
+		"sys/class/dmi/id/product_name": {Data: []byte("Standard PC (Q35 + ICH9, 2009)\n")},
+	})
+	fake.respond("systemd-detect-virt", "kvm\n", nil)
+	fake.respond("arch-chroot /mnt sudo -u alice -H -- yay -S --noconfirm -- broken-pkg", "", errFake)
+	fake.respond("arch-chroot /mnt pacman -Q", "base 3-2\nlinux 6.10.1.arch1-1\n", nil)
+	inst.Run(context.Background())
+
+	r, raw := readReport(t, fake, inst.reportPath)
+	if r.Status != "complete" || r.Version != Version {
+		t.Errorf("status %q version %q, want complete %s", r.Status, r.Version, Version)
+	}
+	if strings.Contains(raw, "hunter2-root") || r.Config.RootPassword != "********" {
+		t.Errorf("root password = %q, want it masked", r.Config.RootPassword)
+	}
+	if r.Config.Hostname != "archbox" || r.Config.Device != "/dev/sda" {
+		t.Errorf("config = %+v, want the install configuration", r.Config)
+	}
+	if len(r.FailedAURPackages) != 1 || r.FailedAURPackages[0] != "broken-pkg" {
+		t.Errorf("failed AUR packages = %v, want [broken-pkg]", r.FailedAURPackages)
+	}
+	if len(r.Packages) != 2 || r.Packages[1] != (Package{"linux", "6.10.1.arch1-1"}) {
+		t.Errorf("packages = %v, want pacman -Q output", r.Packages)
+	}
+	hw := r.Hardware
+	if hw.CPU != "AMD EPYC 7763" || hw.MemoryBytes != 8048576<<10 || hw.Virtualization != "kvm" || hw.Vendor != "QEMU" {
+		t.Errorf("hardware = %+v", hw)
+	}
+
+	status := map[string]string{}
+	for _, p := range r.Phases {
+		status[p.Name] = p.Status
+		if p.Status == "ok" && (p.Started == nil || p.Finished == nil || p.Finished.Before(*p.Started)) {
+			t.Errorf("phase %s has times %v..%v", p.Name, p.Started, p.Finished)
+		}
+	}
+	if status["base_install"] != "ok" || status["luks"] != "skipped" || status["verify"] != "ok" {
+		t.Errorf("phases = %v", status)
+	}
+	assertCommands(t, fake.commands(), []string{"cp " + inst.reportPath + " /mnt/root/archy-report.json"}, nil)
+	if _, err := os.Stat(inst.reportPath); !os.IsNotExist(err) {
+		t.Errorf("report written past the executor: stat = %v", err)
+	}
+}
+
+func TestRunWritesReportOnFailure(t *testing.T) {
+	cfg := testConfig()
+	cfg.RootPassword = "hunter2-root"
+	inst, fake, _ := newTestInstaller(t, cfg)
+	fake.respond("arch-chroot /mnt chpasswd", "bad password hunter2-root", errFake)
+	inst.Run(context.Background())
+
+	r, raw := readReport(t, fake, inst.reportPath)
+	if r.Status != "failed" || r.Error == "" {
+		t.Errorf("status %q error %q, want failed with an error", r.Status, r.Error)
+	}
+	if strings.Contains(raw, "hunter2-root") {
+		t.Error("report contains the root password")
+	}
+	last := r.Phases[len(r.Phases)-1]
+	if last.Name != "system_config" || last.Status != "failed" {
+		t.Errorf("last phase = %+v, want system_config failed", last)
+	}
+	if r.Packages != nil {
+		t.Errorf("packages = %v, want none for a failed install", r.Packages)
+	}
+}
@@ -1,174 +1,215 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/tallenh/archy/internal/config"
 )
 
-func (inst *Installer) prepare() error {
+func (inst *Installer) prepare(ctx context.Context) error {
 	inst.log("Enabling NTP...")
-	return inst.run("timedatectl", "set-ntp", "true")
-}
-
-func (inst *Installer) partition() error {
-	dev := inst.cfg.Device.Path()
-	efiPart := inst.cfg.EFIPartition()
-	rootPart := inst.cfg.RootPartition()
-
-	inst.log("Wiping partition table on " + dev + "...")
-	if err := inst.run("sgdisk", "--zap-all", dev); err != nil {
+	if err := inst.run(ctx, "timedatectl", "set-ntp", "true"); err != nil {
 		return err
 	}
-
-	inst.log("Creating EFI partition (" + inst.cfg.EFISize + ")...")
-	if err := inst.run("sgdisk", "-n", "1:0:+"+inst.cfg.EFISize, "-t", "1:ef00", dev); err != nil {
-		return err
+	if inst.cfg.OfflineRepo != nil {
+		return inst.useOfflineRepo(ctx)
 	}
+	return inst.startPrefetch(ctx)
+}
 
-	inst.log("Creating root partition...")
-	if err := inst.run("sgdisk", "-n", "2:0:0", "-t", "2:8300", dev); err != nil {
-		return err
+func (inst *Installer) partition(ctx context.Context) error {
+	if inst.cfg.Alongside {
+		return inst.partitionAlongside(ctx)
 	}
+	dev := inst.cfg.Device.Path()
+	layout := inst.cfg.PartitionLayout()
 
-	inst.log("Formatting EFI partition...")
-	if err := inst.run("mkfs.fat", "-F32", efiPart); err != nil {
+	inst.log("Wiping partition table on " + dev + "...")
+	if err := inst.run(ctx, "sgdisk", "--zap-all", dev); err != nil {
 		return err
 	}
 
-	// Only format root as btrfs if not encrypting (LUKS path formats after opening)
-	if !inst.cfg.Encrypt {
-		inst.log("Formatting root partition as btrfs...")
-		if err := inst.run("mkfs.btrfs", "-f", "-L", "ArchRoot", rootPart); err != nil {
+	for _, p := range layout {
+		n := strconv.Itoa(p.Number)
+		end := "0"
+		if p.Size != "" {
+			end = "+" + p.Size
+			inst.log("Creating " + p.Name() + " partition (" + p.Size + ")...")
+		} else {
+			inst.log("Creating " + p.Name() + " partition...")
+		}
+		args := []string{"-n", n + ":0:" + end, "-t", n + ":" + p.TypeCode()}
+		if p.Label != "" {
+			args = append(args, "-c", n+":"+p.Label)
+		}
+		if err := inst.run(ctx, "sgdisk", append(args, dev)...); err != nil {
 			return err
 		}
 	}
 
+	for _, p := range layout {
+		part := inst.cfg.PartitionPath(p.Number)
+		fs := partitionFS(inst.cfg, p)
+		switch {
+		case p.IsRoot():
+			// Only format root if not encrypting (LUKS path formats after opening)
+			if !inst.cfg.Encrypt {
+				if err := inst.formatRoot(ctx, part); err != nil {
+					return err
+				}
+			}
+		case fs != "":
+			inst.log("Formatting " + p.Name() + " partition...")
+			name, args := mkfs(fs, "", part)
+			if err := inst.run(ctx, name, args...); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
 
-func (inst *Installer) configureBtrfs() error {
-	btrfsDev := inst.cfg.BtrfsDevice()
-	efiPart := inst.cfg.EFIPartition()
-	opts := "noatime,compress=zstd"
-
-	inst.log("Mounting btrfs root...")
-	if err := inst.run("mount", btrfsDev, "/mnt"); err != nil {
-		return err
+// partitionAlongside creates the root partition in the largest unallocated
+// region of a disk that already holds other systems. The existing
+// partitions, the EFI system partition among them, are left as they are.
+func (inst *Installer) partitionAlongside(ctx context.Context) error {
+	dev := inst.cfg.Device.Path()
+	table := inst.cfg.Device.Table
+	if err := table.CheckAlongside(); err != nil {
+		return fmt.Errorf("%s: %w", dev, err)
 	}
+	free, _ := table.LargestFree()
+	rootPart := inst.cfg.RootPartition()
+	n := strconv.Itoa(table.NextNumber())
 
-	subvolumes := []string{"@", "@home", "@snapshots", "@var_log"}
-	for _, sv := range subvolumes {
-		inst.log("Creating subvolume " + sv + "...")
-		if err := inst.run("btrfs", "subvolume", "create", "/mnt/"+sv); err != nil {
-			return err
-		}
+	inst.log("Keeping the existing partitions and EFI partition " + inst.cfg.EFIPartition() + "...")
+	inst.log("Creating root partition in " + config.FormatBytes(table.Bytes(free.Sectors)) + " of free space...")
+	span := fmt.Sprintf("%s:%d:%d", n, free.Start, free.End())
+	if err := inst.run(ctx, "sgdisk", "-n", span, "-t", n+":8300", dev); err != nil {
+		return err
 	}
 
-	inst.log("Unmounting to remount with subvolumes...")
-	if err := inst.run("umount", "/mnt"); err != nil {
-		return err
+	// Only format root if not encrypting (LUKS path formats after opening)
+	if !inst.cfg.Encrypt {
+		return inst.formatRoot(ctx, rootPart)
 	}
+	return nil
+}
 
-	// Mount @ subvolume
-	if err := inst.run("mount", "-o", opts+",subvol=@", btrfsDev, "/mnt"); err != nil {
-		return err
+// targetMount returns where a path of the installed system is mounted during
+// the install.
+func targetMount(p string) string {
+	if p == "/" {
+		return TargetRoot
 	}
+	return TargetRoot + p
+}
 
-	// Create mount points
-	dirs := []string{"/mnt/boot", "/mnt/home", "/mnt/snapshots", "/mnt/var/log", "/mnt/etc"}
+func (inst *Installer) mkdirs(dirs []string) error {
 	for _, d := range dirs {
-		if err := os.MkdirAll(d, 0o755); err != nil {
+		if err := inst.exec.MkdirAll(d, 0o755); err != nil {
 			return fmt.Errorf("mkdir %s: %w", d, err)
 		}
 	}
+	return nil
+}
 
-	// Mount remaining subvolumes
-	mounts := []struct{ subvol, target string }{
-		{"@home", "/mnt/home"},
-		{"@snapshots", "/mnt/snapshots"},
-		{"@var_log", "/mnt/var/log"},
+// mount mounts m.Source at m.Target with m.Options, if any.
+func (inst *Installer) mount(ctx context.Context, m MountPoint) error {
+	inst.log("Mounting " + m.Source + " at " + m.Target + "...")
+	args := []string{}
+	if m.Options != "" {
+		args = append(args, "-o", m.Options)
 	}
-	for _, m := range mounts {
-		inst.log("Mounting " + m.subvol + " at " + m.target + "...")
-		if err := inst.run("mount", "-o", opts+",subvol="+m.subvol, btrfsDev, m.target); err != nil {
+	return inst.run(ctx, "mount", append(args, m.Source, m.Target)...)
+}
+
+func (inst *Installer) installBase(ctx context.Context) error {
+	// The live mirror list is already written if packages were prefetched
+	cache := inst.awaitPrefetch()
+	if cache == nil {
+		if err := inst.writeMirrorlist(ctx, inst.exec, "live system"); err != nil {
 			return err
 		}
 	}
 
-	// Mount EFI
-	inst.log("Mounting EFI partition at /mnt/boot...")
-	if err := inst.run("mount", efiPart, "/mnt/boot"); err != nil {
+	// Every package goes in one transaction, so databases are read and
+	// hooks such as mkinitcpio run once
+	inst.log("Installing base system and packages (this may take a while)...")
+	args := append(append([]string{"/mnt"}, cache...), inst.packageSet(ctx)...)
+	if err := inst.run(ctx, "pacstrap", args...); err != nil {
 		return err
 	}
-
-	// Generate fstab
-	inst.log("Generating fstab...")
-	return inst.run("bash", "-c", "genfstab -U /mnt >> /mnt/etc/fstab")
-}
-
-func (inst *Installer) installBase() error {
-	inst.log("Installing base system (this may take a while)...")
-	return inst.run("pacstrap", "/mnt", "base", "linux", "linux-firmware", "sudo", "vim", "btrfs-progs")
+	if err := inst.writeMirrorlist(ctx, inst.target, "installed system"); err != nil {
+		return err
+	}
+	if inst.cfg.OfflineRepo != nil {
+		return inst.bindOfflineRepo(ctx)
+	}
+	return nil
 }
 
-func (inst *Installer) configureSystem() error {
+func (inst *Installer) configureSystem(ctx context.Context) error {
 	inst.log("Setting timezone to " + inst.cfg.Timezone + "...")
-	if _, err := inst.chrootShell("ln -sf /usr/share/zoneinfo/" + inst.cfg.Timezone + " /etc/localtime"); err != nil {
+	if _, err := inst.chrootRun(ctx, "ln", "-sf", "/usr/share/zoneinfo/"+inst.cfg.Timezone, "/etc/localtime"); err != nil {
 		return err
 	}
-	if _, err := inst.chrootRun("hwclock", "--systohc"); err != nil {
+	if _, err := inst.chrootRun(ctx, "hwclock", "--systohc"); err != nil {
 		return err
 	}
 
 	inst.log("Configuring locale...")
-	if _, err := inst.chrootShell("sed -i '/en_US.UTF-8/s/^#//' /etc/locale.gen"); err != nil {
+	if _, err := inst.chrootRun(ctx, "sed", "-i", "/en_US.UTF-8/s/^#//", "/etc/locale.gen"); err != nil {
 		return err
 	}
-	if _, err := inst.chrootRun("locale-gen"); err != nil {
+	if _, err := inst.chrootRun(ctx, "locale-gen"); err != nil {
 		return err
 	}
-	if err := os.WriteFile("/mnt/etc/locale.conf", []byte("LANG=en_US.UTF-8\n"), 0o644); err != nil {
+	if err := inst.target.WriteFile("/etc/locale.conf", []byte("LANG=en_US.UTF-8\n"), 0o644); err != nil {
 		return err
 	}
 
 	inst.log("Setting hostname to " + inst.cfg.Hostname + "...")
-	if err := os.WriteFile("/mnt/etc/hostname", []byte(inst.cfg.Hostname+"\n"), 0o644); err != nil {
+	if err := inst.target.WriteFile("/etc/hostname", []byte(inst.cfg.Hostname+"\n"), 0o644); err != nil {
 		return err
 	}
 
 	inst.log("Setting root password...")
-	if _, err := inst.chrootShell(fmt.Sprintf("echo 'root:%s' | chpasswd", inst.cfg.RootPassword)); err != nil {
+	if err := inst.setPassword(ctx, "root", inst.cfg.RootPassword); err != nil {
 		return err
 	}
 
 	inst.log("Creating user " + inst.cfg.Username + "...")
-	if _, err := inst.chrootRun("useradd", "-m", inst.cfg.Username); err != nil {
-		return err
+	if _, err := inst.chrootRun(ctx, "useradd", "-m", inst.cfg.Username); err != nil {
+		// A retried or resumed phase may have created the user already
+		if _, idErr := inst.target.Run(ctx, Command{Name: "id", Args: []string{"-u", inst.cfg.Username}}); idErr != nil {
+			return err
+		}
+		inst.log("User " + inst.cfg.Username + " already exists")
 	}
-	if _, err := inst.chrootShell(fmt.Sprintf("echo '%s:%s' | chpasswd", inst.cfg.Username, inst.cfg.UserPassword)); err != nil {
+	if err := inst.setPassword(ctx, inst.cfg.Username, inst.cfg.UserPassword); err != nil {
 		return err
 	}
-	if _, err := inst.chrootRun("usermod", "-aG", "wheel,audio,video,optical,storage,input", inst.cfg.Username); err != nil {
+	if _, err := inst.chrootRun(ctx, "usermod", "-aG", "wheel,audio,video,optical,storage,input", inst.cfg.Username); err != nil {
 		return err
 	}
 
 	inst.log("Configuring sudoers...")
-	if _, err := inst.chrootShell("sed -i 's/^# %wheel ALL=(ALL:ALL) ALL/%wheel ALL=(ALL:ALL) ALL/' /etc/sudoers"); err != nil {
+	if _, err := inst.chrootRun(ctx, "sed", "-i", "s/^# %wheel ALL=(ALL:ALL) ALL/%wheel ALL=(ALL:ALL) ALL/", "/etc/sudoers"); err != nil {
 		return err
 	}
 
 	if inst.cfg.Shell == "zsh" {
-		inst.log("Installing and setting zsh as default shell...")
-		if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "zsh"); err != nil {
-			return err
-		}
-		if _, err := inst.chrootRun("chsh", "-s", "/bin/zsh", inst.cfg.Username); err != nil {
+		inst.log("Setting zsh as default shell...")
+		if _, err := inst.chrootRun(ctx, "chsh", "-s", "/bin/zsh", inst.cfg.Username); err != nil {
 			return err
 		}
 	}
@@ -176,58 +217,72 @@ func (inst *Installer) configureSystem() error {
 	return nil
 }
 
-func (inst *Installer) configureSwap() error {
-	inst.log("Installing zram-generator...")
-	if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "zram-generator"); err != nil {
-		return err
+// setPassword sets a user's password in the target. The password is fed to
+// chpasswd on stdin so it never appears on a command line or in the log.
+func (inst *Installer) setPassword(ctx context.Context, user, password string) error {
+	inst.logToFile("RUN   arch-chroot %s chpasswd (%s, password on stdin)", TargetRoot, user)
+	out, err := inst.target.Run(ctx, Command{Name: "chpasswd", Stdin: user + ":" + password + "\n"})
+	if err != nil {
+		return fmt.Errorf("chpasswd %s: %w: %s", user, err, out)
 	}
+	return nil
+}
 
+func (inst *Installer) configureSwap(ctx context.Context) error {
 	inst.log("Writing zram-generator config...")
 	conf := fmt.Sprintf("[zram0]\nzram-size = %s\ncompression-algorithm = zstd\nswap-priority = 100\nfs-type = swap\n", inst.cfg.ZRAMSize)
-	return os.WriteFile("/mnt/etc/systemd/zram-generator.conf", []byte(conf), 0o644)
+	return inst.target.WriteFile("/etc/systemd/zram-generator.conf", []byte(conf), 0o644)
 }
 
-func (inst *Installer) installBootloader() error {
-	inst.log("Installing GRUB and efibootmgr...")
-	if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "grub", "efibootmgr"); err != nil {
-		return err
-	}
-
+func (inst *Installer) installBootloader(ctx context.Context) error {
 	if inst.cfg.Encrypt {
-		if err := inst.configureLUKSGrub(); err != nil {
+		if err := inst.configureLUKSGrub(ctx); err != nil {
 			return err
 		}
 	}
 
 	inst.log("Installing GRUB to EFI...")
-	if _, err := inst.chrootRun("grub-install", "--target=x86_64-efi", "--efi-directory=/boot", "--bootloader-id=GRUB"); err != nil {
+	if _, err := inst.chrootRun(ctx, "grub-install", "--target=x86_64-efi", "--efi-directory="+inst.cfg.EFIMountpoint(), "--bootloader-id=GRUB"); err != nil {
 		return err
 	}
 
+	// GRUB leaves other systems out of its menu unless os-prober is enabled
+	if inst.cfg.Alongside {
+		inst.log("Enabling os-prober for the other installed systems...")
+		if _, err := inst.chrootRun(ctx, "sed", "-i",
+			`s/^#\?GRUB_DISABLE_OS_PROBER=.*/GRUB_DISABLE_OS_PROBER=false/`,
+			"/etc/default/grub",
+		); err != nil {
+			return err
+		}
+	}
+
 	inst.log("Generating GRUB config...")
-	_, err := inst.chrootRun("grub-mkconfig", "-o", "/boot/grub/grub.cfg")
+	_, err := inst.chrootRun(ctx, "grub-mkconfig", "-o", "/boot/grub/grub.cfg")
 	return err
 }
 
-func (inst *Installer) enableServices() error {
-	inst.log("Installing and enabling NetworkManager...")
-	if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "networkmanager"); err != nil {
-		return err
-	}
-	if _, err := inst.chrootRun("systemctl", "enable", "NetworkManager"); err != nil {
+func (inst *Installer) enableServices(ctx context.Context) error {
+	inst.log("Enabling NetworkManager...")
+	if _, err := inst.chrootRun(ctx, "systemctl", "enable", "NetworkManager"); err != nil {
 		return err
 	}
 
-	// Install guest agents if running in QEMU/Proxmox
-	if isQEMU() {
-		inst.log("QEMU/Proxmox detected, installing guest agents...")
-		if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "qemu-guest-agent", "spice-vdagent"); err != nil {
+	// Weekly TRIM keeps an SSD fast even without the discard mount option
+	if inst.cfg.SSD() {
+		inst.log("Enabling fstrim.timer...")
+		if _, err := inst.chrootRun(ctx, "systemctl", "enable", "fstrim.timer"); err != nil {
 			return err
 		}
-		if _, err := inst.chrootRun("systemctl", "enable", "qemu-guest-agent"); err != nil {
+	}
+
+	// Guest agents are installed with the base system in QEMU/Proxmox
+	if slices.Contains(inst.packageSet(ctx), "qemu-guest-agent") {
+		inst.log("QEMU/Proxmox detected, enabling guest agents...")
+		if _, err := inst.chrootRun(ctx, "systemctl", "enable", "qemu-guest-agent"); err != nil {
 			return err
 		}
-		if _, err := inst.chrootRun("systemctl", "enable", "spice-vdagentd.socket"); err != nil {
+		if _, err := inst.chrootRun(ctx, "systemctl", "enable", "spice-vdagentd.socket"); err != nil {
 			return err
 		}
 	}
@@ -235,46 +290,47 @@ func (inst *Installer) enableServices() error {
 	return nil
 }
 
-func isQEMU() bool {
-	out, err := exec.Command("systemd-detect-virt").Output()
-	if err != nil {
-		return false
-	}
-	virt := strings.TrimSpace(string(out))
+func (inst *Installer) isQEMU(ctx context.Context) bool {
+	virt := inst.virtualization(ctx)
 	return virt == "kvm" || virt == "qemu"
 }
 
-func (inst *Installer) configureSSHD() error {
-	inst.log("Installing openssh...")
-	if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "openssh"); err != nil {
-		return err
+// virtualization returns what systemd-detect-virt reports, running it only
+// until it has answered once. It prints "none" on bare metal.
+func (inst *Installer) virtualization(ctx context.Context) string {
+	if inst.virt == "" {
+		out, _ := inst.exec.Run(ctx, Command{Name: "systemd-detect-virt"})
+		inst.virt = strings.TrimSpace(string(out))
 	}
+	return inst.virt
+}
 
+func (inst *Installer) configureSSHD(ctx context.Context) error {
 	inst.log("Configuring sshd...")
 	sshdConfig := "PermitRootLogin no\nPasswordAuthentication no\nPubkeyAuthentication yes\n"
-	if err := os.MkdirAll("/mnt/etc/ssh/sshd_config.d", 0o755); err != nil {
+	if err := inst.target.MkdirAll("/etc/ssh/sshd_config.d", 0o755); err != nil {
 		return fmt.Errorf("mkdir sshd_config.d: %w", err)
 	}
-	if err := os.WriteFile("/mnt/etc/ssh/sshd_config.d/10-archy.conf", []byte(sshdConfig), 0o644); err != nil {
+	if err := inst.target.WriteFile("/etc/ssh/sshd_config.d/10-archy.conf", []byte(sshdConfig), 0o644); err != nil {
 		return fmt.Errorf("write sshd config: %w", err)
 	}
 
 	inst.log("Enabling sshd service...")
-	if _, err := inst.chrootRun("systemctl", "enable", "sshd"); err != nil {
+	if _, err := inst.chrootRun(ctx, "systemctl", "enable", "sshd"); err != nil {
 		return err
 	}
 
 	if inst.cfg.SSHPubKey != "" {
 		inst.log("Installing SSH public key for " + inst.cfg.Username + "...")
-		sshDir := fmt.Sprintf("/mnt/home/%s/.ssh", inst.cfg.Username)
-		if err := os.MkdirAll(sshDir, 0o700); err != nil {
+		sshDir := fmt.Sprintf("/home/%s/.ssh", inst.cfg.Username)
+		if err := inst.target.MkdirAll(sshDir, 0o700); err != nil {
 			return fmt.Errorf("mkdir .ssh: %w", err)
 		}
-		if err := os.WriteFile(sshDir+"/authorized_keys", []byte(inst.cfg.SSHPubKey+"\n"), 0o600); err != nil {
+		if err := inst.target.WriteFile(sshDir+"/authorized_keys", []byte(inst.cfg.SSHPubKey+"\n"), 0o600); err != nil {
 			return fmt.Errorf("write authorized_keys: %w", err)
 		}
-		chownCmd := fmt.Sprintf("chown -R %s:%s /home/%s/.ssh", inst.cfg.Username, inst.cfg.Username, inst.cfg.Username)
-		if _, err := inst.chrootShell(chownCmd); err != nil {
+		owner := inst.cfg.Username + ":" + inst.cfg.Username
+		if _, err := inst.chrootRun(ctx, "chown", "-R", owner, sshDir); err != nil {
 			return fmt.Errorf("chown .ssh: %w", err)
 		}
 	}
@@ -282,20 +338,15 @@ func (inst *Installer) configureSSHD() error {
 	return nil
 }
 
-func (inst *Installer) installDocker() error {
-	inst.log("Installing Docker...")
-	if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "docker"); err != nil {
-		return err
-	}
-
+func (inst *Installer) installDocker(ctx context.Context) error {
 	inst.log("Enabling Docker service...")
-	if _, err := inst.chrootRun("systemctl", "enable", "docker"); err != nil {
+	if _, err := inst.chrootRun(ctx, "systemctl", "enable", "docker"); err != nil {
 		return err
 	}
 
 	if inst.cfg.DockerGroup {
 		inst.log("Adding " + inst.cfg.Username + " to docker group...")
-		if _, err := inst.chrootRun("usermod", "-aG", "docker", inst.cfg.Username); err != nil {
+		if _, err := inst.chrootRun(ctx, "usermod", "-aG", "docker", inst.cfg.Username); err != nil {
 			return err
 		}
 	}
@@ -303,27 +354,19 @@ func (inst *Installer) installDocker() error {
 	return nil
 }
 
-func (inst *Installer) installSoftware() error {
-	inst.log("Installing base-devel and git...")
-	if _, err := inst.chrootRun("pacman", "-S", "--noconfirm", "base-devel", "git", "go"); err != nil {
-		return err
-	}
-
+// installSoftware builds yay and installs the AUR packages. Repository
+// packages, including base-devel and git for yay, come with the base system.
+func (inst *Installer) installSoftware(ctx context.Context) error {
 	yayInstalled := false
-	inst.log("Installing yay AUR helper...")
-	yayCmd := fmt.Sprintf("su - %s -c 'git clone https://aur.archlinux.org/yay.git /tmp/yay && cd /tmp/yay && makepkg --noconfirm' && pacman -U --noconfirm /tmp/yay/yay-*.pkg.tar.zst", inst.cfg.Username)
-	if _, err := inst.chrootShell(yayCmd); err != nil {
-		// yay install is non-fatal
-		inst.log("Warning: yay install failed (can be installed manually later)")
+	if inst.cfg.OfflineRepo != nil {
+		inst.log("Offline install: skipping yay (the AUR needs network access)")
 	} else {
-		yayInstalled = true
-	}
-
-	if len(inst.cfg.Packages) > 0 {
-		inst.log("Installing additional packages...")
-		args := append([]string{"-S", "--noconfirm"}, inst.cfg.Packages...)
-		if _, err := inst.chrootRun("pacman", args...); err != nil {
-			return err
+		inst.log("Installing yay AUR helper...")
+		if err := inst.installYay(ctx); err != nil {
+			// yay install is non-fatal
+			inst.log("Warning: yay install failed (can be installed manually later)")
+		} else {
+			yayInstalled = true
 		}
 	}
 
@@ -334,21 +377,21 @@ func (inst *Installer) installSoftware() error {
 		} else {
 			sudoer := fmt.Sprintf("/etc/sudoers.d/90-archy-%s", inst.cfg.Username)
 			nopasswd := fmt.Sprintf("%s ALL=(ALL) NOPASSWD: ALL", inst.cfg.Username)
-			if _, err := inst.chrootShell(fmt.Sprintf("echo '%s' > %s && chmod 440 %s", nopasswd, sudoer, sudoer)); err != nil {
-				return err
+			if err := inst.target.WriteFile(sudoer, []byte(nopasswd+"\n"), 0o440); err != nil {
+				return fmt.Errorf("write %s: %w", sudoer, err)
 			}
 			var failed []string
 			for _, pkg := range inst.cfg.AURPackages {
 				inst.log("Installing AUR package: " + pkg + "...")
-				cmd := fmt.Sprintf("su - %s -c 'yay -S --noconfirm %s'", inst.cfg.Username, pkg)
-				if _, err := inst.chrootShell(cmd); err != nil {
+				if _, err := inst.chrootRunAs(ctx, inst.cfg.Username, "yay", "-S", "--noconfirm", "--", pkg); err != nil {
 					inst.log("Warning: AUR package " + pkg + " failed to install")
 					failed = append(failed, pkg)
 				}
 			}
-			if _, err := inst.chrootShell("rm -f " + sudoer); err != nil {
+			if _, err := inst.chrootRun(ctx, "rm", "-f", sudoer); err != nil {
 				inst.log("Warning: failed to remove temporary sudoers file")
 			}
+			inst.report.FailedAURPackages = failed
 			if len(failed) > 0 {
 				inst.log("Warning: failed AUR packages (can be installed manually later): " +
 					strings.Join(failed, ", "))
@@ -359,24 +402,27 @@ func (inst *Installer) installSoftware() error {
 	return nil
 }
 
-func (inst *Installer) installDesktop() error {
-	pkgs := inst.cfg.Desktop.Packages()
-	if len(pkgs) == 0 {
-		return nil
+// installYay builds yay from the AUR as the user and installs the package.
+func (inst *Installer) installYay(ctx context.Context) error {
+	if _, err := inst.chrootRunAs(ctx, inst.cfg.Username, "git", "clone", "https://aur.archlinux.org/yay.git", "/tmp/yay"); err != nil {
+		return err
 	}
-
-	inst.log("Installing " + inst.cfg.Desktop.String() + " packages...")
-	args := append([]string{"-S", "--noconfirm"}, pkgs...)
-	if _, err := inst.chrootRun("pacman", args...); err != nil {
+	if _, err := inst.chrootRunAs(ctx, inst.cfg.Username, "bash", "-c", "cd /tmp/yay && makepkg --noconfirm"); err != nil {
 		return err
 	}
+	_, err := inst.chrootShell(ctx, "pacman -U --noconfirm /tmp/yay/yay-*.pkg.tar.zst")
+	return err
+}
 
+// installDesktop configures the desktop environment installed with the base
+// system.
+func (inst *Installer) installDesktop(ctx context.Context) error {
 	dm := inst.cfg.Desktop.DisplayManager()
 	if dm != "" {
 		inst.log("Enabling " + dm + "...")
-		if _, err := inst.chrootRun("systemctl", "enable", dm); err != nil {
+		if _, err := inst.chrootRun(ctx, "systemctl", "enable", dm); err != nil {
 			// Try with .service suffix
-			_, err = inst.chrootRun("systemctl", "enable", dm+".service")
+			_, err = inst.chrootRun(ctx, "systemctl", "enable", dm+".service")
 			if err != nil {
 				return fmt.Errorf("enable %s: %w", dm, err)
 			}
@@ -395,10 +441,10 @@ picture-uri-dark=''
 primary-color='#231f30'
 color-shading-type='solid'
 `
-		if err := os.WriteFile("/mnt/usr/share/glib-2.0/schemas/99-archy.gschema.override", []byte(override), 0o644); err != nil {
+		if err := inst.target.WriteFile("/usr/share/glib-2.0/schemas/99-archy.gschema.override", []byte(override), 0o644); err != nil {
 			return err
 		}
-		if _, err := inst.chrootRun("glib-compile-schemas", "/usr/share/glib-2.0/schemas/"); err != nil {
+		if _, err := inst.chrootRun(ctx, "glib-compile-schemas", "/usr/share/glib-2.0/schemas/"); err != nil {
 			return err
 		}
 	}
@@ -406,7 +452,7 @@ color-shading-type='solid'
 	return nil
 }
 
-func (inst *Installer) installDotfiles() error {
+func (inst *Installer) installDotfiles(ctx context.Context) error {
 	for _, df := range inst.cfg.Dotfiles {
 		dest := df.Dest
 		isUserOwned := false
@@ -415,9 +461,8 @@ func (inst *Installer) installDotfiles() error {
 			isUserOwned = true
 		}
 
-		targetPath := "/mnt" + dest
-		parentDir := filepath.Dir(targetPath)
-		if err := os.MkdirAll(parentDir, 0o755); err != nil {
+		parentDir := filepath.Dir(dest)
+		if err := inst.target.MkdirAll(parentDir, 0o755); err != nil {
 			return fmt.Errorf("mkdir %s: %w", parentDir, err)
 		}
 
@@ -434,15 +479,15 @@ func (inst *Installer) installDotfiles() error {
 			return fmt.Errorf("read dotfile %s: %w", df.Src, err)
 		}
 
-		if err := os.WriteFile(targetPath, data, 0o644); err != nil {
-			return fmt.Errorf("write dotfile %s: %w", targetPath, err)
+		if err := inst.target.WriteFile(dest, data, 0o644); err != nil {
+			return fmt.Errorf("write dotfile %s: %w", dest, err)
 		}
 
 		inst.log(fmt.Sprintf("Installed %s → %s", df.Src, df.Dest))
 
 		if isUserOwned {
-			chownCmd := fmt.Sprintf("chown %s:%s %s", inst.cfg.Username, inst.cfg.Username, dest)
-			if _, err := inst.chrootShell(chownCmd); err != nil {
+			owner := inst.cfg.Username + ":" + inst.cfg.Username
+			if _, err := inst.chrootRun(ctx, "chown", owner, dest); err != nil {
 				return fmt.Errorf("chown %s: %w", dest, err)
 			}
 		}
@@ -450,14 +495,34 @@ func (inst *Installer) installDotfiles() error {
 	return nil
 }
 
-// cleanupMounts attempts to unmount and close LUKS. Called on failure or Ctrl+C.
+// CleanupMounts attempts to unmount and close LUKS. Called when an install is
+// cancelled and before resuming one. It runs to completion even if the
+// install's context has been cancelled.
 func (inst *Installer) CleanupMounts() {
-	targets := []string{"/mnt/boot", "/mnt/home", "/mnt/snapshots", "/mnt/var/log", "/mnt"}
+	ctx := context.Background()
+	for _, dev := range inst.swapPartitions() {
+		_, _ = inst.exec.Run(ctx, Command{Name: "swapoff", Args: []string{dev}})
+	}
+	// Unmount beneath the root first, the most deeply nested first
+	var targets []string
+	parts := inst.partitionMounts()
+	for i := len(parts) - 1; i >= 0; i-- {
+		targets = append(targets, parts[i].Target)
+	}
+	subvolumes := inst.cfg.BtrfsSubvolumes()
+	for i := len(subvolumes) - 1; i >= 0; i-- {
+		if sv := subvolumes[i]; sv.Mountpoint != "/" {
+			targets = append(targets, targetMount(sv.Mountpoint))
+		}
+	}
+	targets = append(targets, TargetRoot)
+	if inst.cfg.OfflineRepo != nil {
+		targets = append([]string{offlineRepoTarget}, targets...)
+	}
 	for _, t := range targets {
-		_ = exec.Command("umount", "-l", t).Run()
+		_, _ = inst.exec.Run(ctx, Command{Name: "umount", Args: []string{"-l", t}})
 	}
 	if inst.cfg.Encrypt {
-		_ = exec.Command("cryptsetup", "close", "cryptroot").Run()
+		_, _ = inst.exec.Run(ctx, Command{Name: "cryptsetup", Args: []string{"close", "cryptroot"}})
 	}
 }
-
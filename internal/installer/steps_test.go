@@ -0,0 +1,667 @@
+package installer
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+func TestPhases(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(cfg *config.InstallConfig, f *fakeExecutor)
+		phase   func(inst *Installer, ctx context.Context) error
+		want    []string          // substrings of commands that must run, in order
+		notWant []string          // substrings no command may contain
+		files   map[string]string // path → substring the written file must contain
+		wantErr bool
+	}{
+		{
+			name:  "prepare",
+			phase: (*Installer).prepare,
+			want:  []string{"timedatectl set-ntp true"},
+		},
+		{
+			name:  "partition sata",
+			phase: (*Installer).partition,
+			want: []string{
+				"sgdisk --zap-all /dev/sda",
+				"sgdisk -n 1:0:+512M -t 1:ef00 /dev/sda",
+				"sgdisk -n 2:0:0 -t 2:8300 /dev/sda",
+				"mkfs.fat -F32 /dev/sda1",
+				"mkfs.btrfs -f -L ArchRoot /dev/sda2",
+			},
+		},
+		{
+			name: "partition ext4",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Filesystem = config.FilesystemExt4
+			},
+			phase:   (*Installer).partition,
+			want:    []string{"mkfs.fat -F32 /dev/sda1", "mkfs.ext4 -F -L ArchRoot /dev/sda2"},
+			notWant: []string{"mkfs.btrfs"},
+		},
+		{
+			name: "partition nvme encrypted leaves root unformatted",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Device = config.BlockDevice{Name: "nvme0n1"}
+				cfg.Encrypt = true
+			},
+			phase:   (*Installer).partition,
+			want:    []string{"sgdisk --zap-all /dev/nvme0n1", "mkfs.fat -F32 /dev/nvme0n1p1"},
+			notWant: []string{"mkfs.btrfs"},
+		},
+		{
+			name: "partition stops when wipe fails",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("sgdisk --zap-all", "", errFake)
+			},
+			phase:   (*Installer).partition,
+			notWant: []string{"mkfs.fat"},
+			wantErr: true,
+		},
+		{
+			name: "luks",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				cfg.LUKSPassphrase = "correct horse"
+			},
+			phase: (*Installer).setupLUKS,
+			want: []string{
+				"cryptsetup luksFormat --type luks2 --pbkdf pbkdf2 /dev/sda2",
+				"cryptsetup open /dev/sda2 cryptroot",
+				"mkfs.btrfs -f -L ArchRoot /dev/mapper/cryptroot",
+			},
+		},
+		{
+			name: "luks xfs",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				cfg.Filesystem = config.FilesystemXFS
+			},
+			phase: (*Installer).setupLUKS,
+			want:  []string{"cryptsetup open /dev/sda2 cryptroot", "mkfs.xfs -f -L ArchRoot /dev/mapper/cryptroot"},
+		},
+		{
+			name: "luks open failure",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				f.respond("cryptsetup open", "No key available", errFake)
+			},
+			phase:   (*Installer).setupLUKS,
+			notWant: []string{"mkfs.btrfs"},
+			wantErr: true,
+		},
+		{
+			name:  "btrfs",
+			phase: (*Installer).mountFilesystems,
+			want: []string{
+				"mount /dev/sda2 /mnt",
+				"btrfs subvolume create /mnt/@",
+				"btrfs subvolume create /mnt/@var_log",
+				"umount /mnt",
+				"mount -o noatime,compress=zstd,subvol=@ /dev/sda2 /mnt",
+				"mount -o noatime,compress=zstd,subvol=@home /dev/sda2 /mnt/home",
+				"mount /dev/sda1 /mnt/boot",
+				"genfstab -U /mnt",
+			},
+		},
+		{
+			name: "btrfs on nvme",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Device = config.BlockDevice{Name: "nvme0n1", SSD: true, NVMe: true}
+				cfg.Mount.SpaceCache = "v2"
+			},
+			phase: (*Installer).mountFilesystems,
+			want: []string{
+				"mount -o noatime,compress=zstd:1,ssd,discard=async,space_cache=v2,subvol=@ /dev/nvme0n1p2 /mnt",
+				"mount -o noatime,compress=zstd:1,ssd,discard=async,space_cache=v2,subvol=@home /dev/nvme0n1p2 /mnt/home",
+			},
+		},
+		{
+			name: "ext4 has no subvolumes",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Filesystem = config.FilesystemExt4
+			},
+			phase: (*Installer).mountFilesystems,
+			want: []string{
+				"mount -o noatime /dev/sda2 /mnt",
+				"mount /dev/sda1 /mnt/boot",
+				"genfstab -U /mnt",
+			},
+			notWant: []string{"btrfs", "subvol", "umount"},
+		},
+		{
+			name: "xfs on ssd",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Filesystem = config.FilesystemXFS
+				cfg.Device.SSD = true
+				cfg.Encrypt = true
+				cfg.Mount.Discard = "sync"
+			},
+			phase: (*Installer).mountFilesystems,
+			want:  []string{"mount -o noatime,discard /dev/mapper/cryptroot /mnt"},
+		},
+		{
+			name: "base on xfs",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Filesystem = config.FilesystemXFS
+			},
+			phase:   (*Installer).installBase,
+			want:    []string{"pacstrap /mnt base linux linux-firmware sudo vim xfsprogs zram-generator"},
+			notWant: []string{"btrfs-progs"},
+		},
+		{
+			name:  "base",
+			phase: (*Installer).installBase,
+			want:  []string{"pacstrap /mnt base linux linux-firmware sudo vim btrfs-progs zram-generator grub efibootmgr networkmanager base-devel git go"},
+		},
+		{
+			name: "base installs every package in one transaction",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Shell = "zsh"
+				cfg.SSHD = true
+				cfg.Docker = true
+				cfg.Desktop = config.DesktopKDE
+				cfg.Packages = []string{"tmux", "git"}
+				f.respond("systemd-detect-virt", "kvm\n", nil)
+			},
+			phase: (*Installer).installBase,
+			want: []string{"pacstrap /mnt base linux linux-firmware sudo vim btrfs-progs zsh zram-generator grub efibootmgr networkmanager " +
+				"openssh docker plasma-meta kde-applications-meta sddm base-devel git go tmux qemu-guest-agent spice-vdagent"},
+			notWant: []string{"pacman -S"},
+		},
+		{
+			name: "system config with zsh",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Shell = "zsh"
+			},
+			phase: (*Installer).configureSystem,
+			want: []string{
+				"arch-chroot /mnt hwclock --systohc",
+				"arch-chroot /mnt locale-gen",
+				"arch-chroot /mnt useradd -m alice",
+				"arch-chroot /mnt usermod -aG wheel,audio,video,optical,storage,input alice",
+				"arch-chroot /mnt chsh -s /bin/zsh alice",
+			},
+			files: map[string]string{
+				"/mnt/etc/hostname":    "archbox\n",
+				"/mnt/etc/locale.conf": "LANG=en_US.UTF-8",
+			},
+		},
+		{
+			name:    "system config with bash",
+			phase:   (*Installer).configureSystem,
+			notWant: []string{"chsh"},
+		},
+		{
+			name: "system config stops when useradd fails",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("arch-chroot /mnt useradd", "useradd: cannot lock /etc/passwd", errFake)
+				f.respond("arch-chroot /mnt id -u alice", "id: 'alice': no such user", errFake)
+			},
+			phase:   (*Installer).configureSystem,
+			notWant: []string{"usermod"},
+			wantErr: true,
+		},
+		{
+			name: "system config keeps a user made by an earlier attempt",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("arch-chroot /mnt useradd", "useradd: user 'alice' already exists", errFake)
+				f.respond("arch-chroot /mnt id -u alice", "1000\n", nil)
+			},
+			phase: (*Installer).configureSystem,
+			want:  []string{"arch-chroot /mnt useradd -m alice", "arch-chroot /mnt id -u alice", "arch-chroot /mnt usermod -aG"},
+		},
+		{
+			name:    "swap",
+			phase:   (*Installer).configureSwap,
+			notWant: []string{"pacman"},
+			files:   map[string]string{"/mnt/etc/systemd/zram-generator.conf": "zram-size = ram / 2\n"},
+		},
+		{
+			name:    "bootloader",
+			phase:   (*Installer).installBootloader,
+			want:    []string{"grub-install --target=x86_64-efi", "grub-mkconfig -o /boot/grub/grub.cfg"},
+			notWant: []string{"blkid", "mkinitcpio", "pacman"},
+		},
+		{
+			name: "bootloader encrypted",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				f.respond("blkid -s UUID -o value /dev/sda2", "1234-abcd\n", nil)
+			},
+			phase: (*Installer).installBootloader,
+			want: []string{
+				"BINARIES=(btrfs)",
+				"arch-chroot /mnt mkinitcpio -P",
+				"cryptdevice=UUID=1234-abcd:cryptroot root=/dev/mapper/cryptroot",
+				"grub-install",
+			},
+		},
+		{
+			name: "bootloader encrypted ext4",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				cfg.Filesystem = config.FilesystemExt4
+				f.respond("blkid -s UUID -o value /dev/sda2", "1234-abcd\n", nil)
+			},
+			phase:   (*Installer).installBootloader,
+			want:    []string{"block encrypt filesystems fsck", "arch-chroot /mnt mkinitcpio -P"},
+			notWant: []string{"BINARIES"},
+		},
+		{
+			name: "bootloader encrypted ssd allows discards",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				cfg.Device.SSD = true
+				f.respond("blkid -s UUID -o value /dev/sda2", "1234-abcd\n", nil)
+			},
+			phase: (*Installer).installBootloader,
+			want:  []string{"cryptdevice=UUID=1234-abcd:cryptroot:allow-discards root=/dev/mapper/cryptroot"},
+		},
+		{
+			name: "services on ssd",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Device.SSD = true
+			},
+			phase: (*Installer).enableServices,
+			want:  []string{"systemctl enable NetworkManager", "systemctl enable fstrim.timer"},
+		},
+		{
+			name: "services on qemu",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("systemd-detect-virt", "kvm\n", nil)
+			},
+			phase: (*Installer).enableServices,
+			want: []string{
+				"systemctl enable NetworkManager",
+				"systemctl enable qemu-guest-agent",
+				"systemctl enable spice-vdagentd.socket",
+			},
+			notWant: []string{"pacman"},
+		},
+		{
+			name: "services on bare metal",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("systemd-detect-virt", "none\n", errFake)
+			},
+			phase:   (*Installer).enableServices,
+			want:    []string{"systemctl enable NetworkManager"},
+			notWant: []string{"qemu-guest-agent", "fstrim"},
+		},
+		{
+			name: "sshd with public key",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.SSHD = true
+				cfg.SSHPubKey = "ssh-ed25519 AAAAC3Nza alice@laptop"
+			},
+			phase: (*Installer).configureSSHD,
+			want:  []string{"systemctl enable sshd", "chown -R alice:alice /home/alice/.ssh"},
+			files: map[string]string{
+				"/mnt/etc/ssh/sshd_config.d/10-archy.conf": "PasswordAuthentication no",
+				"/mnt/home/alice/.ssh/authorized_keys":     "ssh-ed25519 AAAAC3Nza alice@laptop\n",
+			},
+		},
+		{
+			name:    "sshd without public key",
+			phase:   (*Installer).configureSSHD,
+			want:    []string{"systemctl enable sshd"},
+			notWant: []string{"chown"},
+		},
+		{
+			name:    "docker with group",
+			phase:   (*Installer).installDocker,
+			want:    []string{"systemctl enable docker", "usermod -aG docker alice"},
+			notWant: []string{"pacman"},
+		},
+		{
+			name: "docker without group",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.DockerGroup = false
+			},
+			phase:   (*Installer).installDocker,
+			notWant: []string{"usermod"},
+		},
+		{
+			name: "gnome desktop",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Desktop = config.DesktopGNOME
+			},
+			phase:   (*Installer).installDesktop,
+			want:    []string{"systemctl enable gdm", "glib-compile-schemas"},
+			notWant: []string{"pacman"},
+			files:   map[string]string{"/mnt/usr/share/glib-2.0/schemas/99-archy.gschema.override": "prefer-dark"},
+		},
+		{
+			name: "display manager falls back to .service unit",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Desktop = config.DesktopKDE
+				f.respond("arch-chroot /mnt systemctl enable sddm", "", errFake)
+				f.respond("arch-chroot /mnt systemctl enable sddm.service", "", nil)
+			},
+			phase: (*Installer).installDesktop,
+			want:  []string{"systemctl enable sddm", "systemctl enable sddm.service"},
+		},
+		{
+			name: "display manager failure",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Desktop = config.DesktopHyprland
+				f.respond("arch-chroot /mnt systemctl enable sddm", "", errFake)
+			},
+			phase:   (*Installer).installDesktop,
+			wantErr: true,
+		},
+		{
+			name: "software with AUR packages",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Packages = []string{"tmux", "neovim"}
+				cfg.AURPackages = []string{"paru-bin", "broken-pkg"}
+				f.respond("arch-chroot /mnt sudo -u alice -H -- yay -S --noconfirm -- broken-pkg", "", errFake)
+			},
+			phase: (*Installer).installSoftware,
+			want: []string{
+				"yay.git",
+				"pacman -U --noconfirm /tmp/yay/yay-*.pkg.tar.zst",
+				"yay -S --noconfirm -- paru-bin",
+				"yay -S --noconfirm -- broken-pkg",
+				"rm -f /etc/sudoers.d/90-archy-alice",
+			},
+			files: map[string]string{
+				"/mnt/etc/sudoers.d/90-archy-alice": "alice ALL=(ALL) NOPASSWD: ALL",
+			},
+		},
+		{
+			name: "software skips AUR packages without yay",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.AURPackages = []string{"paru-bin"}
+				f.respond("arch-chroot /mnt sudo -u alice -H -- git clone", "", errFake)
+			},
+			phase:   (*Installer).installSoftware,
+			notWant: []string{"yay -S"},
+		},
+		{
+			name: "dotfiles from bundle",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.BundleFS = fstest.MapFS{
+					"dots/zshrc": {Data: []byte("export EDITOR=vim\n")},
+					"dots/motd":  {Data: []byte("welcome\n")},
+				}
+				cfg.Dotfiles = []config.Dotfile{
+					{Src: "dots/zshrc", Dest: "~/.zshrc"},
+					{Src: "dots/motd", Dest: "/etc/motd"},
+				}
+			},
+			phase:   (*Installer).installDotfiles,
+			want:    []string{"chown alice:alice /home/alice/.zshrc"},
+			notWant: []string{"/etc/motd"},
+			files: map[string]string{
+				"/mnt/home/alice/.zshrc": "export EDITOR=vim",
+				"/mnt/etc/motd":          "welcome",
+			},
+		},
+		{
+			name: "dotfile missing from bundle",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.BundleFS = fstest.MapFS{}
+				cfg.Dotfiles = []config.Dotfile{{Src: "dots/zshrc", Dest: "~/.zshrc"}}
+			},
+			phase:   (*Installer).installDotfiles,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			inst, fake, _ := newTestInstaller(t, cfg)
+			if tt.setup != nil {
+				tt.setup(cfg, fake)
+			}
+
+			err := tt.phase(inst, context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			assertCommands(t, fake.commands(), tt.want, tt.notWant)
+			for path, want := range tt.files {
+				got, ok := fake.files[path]
+				if !ok {
+					t.Errorf("%s was not written", path)
+				} else if !strings.Contains(got, want) {
+					t.Errorf("%s = %q, want it to contain %q", path, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMountBtrfsCustomSubvolumes(t *testing.T) {
+	cfg := testConfig()
+	cfg.Subvolumes = []config.Subvolume{
+		{Name: "@", Mountpoint: "/"},
+		{Name: "@docker", Mountpoint: "/var/lib/docker", NoDataCOW: true},
+		{Name: "@var", Mountpoint: "/var"},
+		{Name: "@home", Mountpoint: "/home", Options: "compress=zstd:1"},
+	}
+	inst, fake, _ := newTestInstaller(t, cfg)
+
+	if err := inst.mountFilesystems(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"btrfs subvolume create /mnt/@docker",
+			"chattr +C /mnt/@docker",
+			"mount -o noatime,compress=zstd,subvol=@ /dev/sda2 /mnt",
+			"mount -o noatime,compress=zstd,compress=zstd:1,subvol=@home /dev/sda2 /mnt/home",
+			"mount -o noatime,compress=zstd,subvol=@var /dev/sda2 /mnt/var",
+			"mount -o noatime,compress=zstd,subvol=@docker /dev/sda2 /mnt/var/lib/docker",
+			"mount /dev/sda1 /mnt/boot",
+		},
+		[]string{"chattr +C /mnt/@var", "@snapshots"},
+	)
+	// @var hides the mount point made for @docker before it was mounted
+	want := []string{"/mnt/boot", "/mnt/home", "/mnt/var", "/mnt/var/lib/docker", "/mnt/etc", "/mnt/var/lib/docker"}
+	if !slices.Equal(fake.dirs, want) {
+		t.Errorf("dirs = %q, want %q", fake.dirs, want)
+	}
+
+	fake.cmds = nil
+	inst.CleanupMounts()
+	assertCommands(t, fake.commands(),
+		[]string{"umount -l /mnt/boot", "umount -l /mnt/var/lib/docker", "umount -l /mnt/var", "umount -l /mnt/home", "umount -l /mnt"},
+		nil,
+	)
+}
+
+func TestPartitionLayout(t *testing.T) {
+	cfg := testConfig()
+	cfg.Partitions = []config.Partition{
+		{Size: "1G", Type: "xbootldr", Label: "boot", Filesystem: "ext4", Mountpoint: "/boot"},
+		{Size: "512M", Type: "efi", Mountpoint: "/boot/efi"},
+		{Size: "8G", Type: "swap", Filesystem: "swap"},
+		{Size: "40G", Type: "root", Mountpoint: "/"},
+		{Type: "linux", Label: "data", Filesystem: "xfs", Mountpoint: "/data"},
+	}
+	inst, fake, _ := newTestInstaller(t, cfg)
+	ctx := context.Background()
+
+	for _, phase := range []func(*Installer, context.Context) error{
+		(*Installer).partition, (*Installer).mountFilesystems, (*Installer).installBase, (*Installer).installBootloader,
+	} {
+		if err := phase(inst, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"sgdisk -n 1:0:+1G -t 1:ea00 -c 1:boot /dev/sda",
+			"sgdisk -n 2:0:+512M -t 2:ef00 /dev/sda",
+			"sgdisk -n 3:0:+8G -t 3:8200 /dev/sda",
+			"sgdisk -n 4:0:+40G -t 4:8304 /dev/sda",
+			"sgdisk -n 5:0:0 -t 5:8300 -c 5:data /dev/sda",
+			"mkfs.ext4 -F /dev/sda1",
+			"mkfs.fat -F32 /dev/sda2",
+			"mkswap /dev/sda3",
+			"mkfs.btrfs -f -L ArchRoot /dev/sda4",
+			"mkfs.xfs -f /dev/sda5",
+			"mount -o noatime,compress=zstd,subvol=@ /dev/sda4 /mnt",
+			"mount /dev/sda1 /mnt/boot",
+			"mount /dev/sda2 /mnt/boot/efi",
+			"mount /dev/sda5 /mnt/data",
+			"swapon /dev/sda3",
+			"genfstab -U /mnt",
+			"pacstrap /mnt base linux linux-firmware sudo vim e2fsprogs btrfs-progs xfsprogs zram-generator",
+			"grub-install --target=x86_64-efi --efi-directory=/boot/efi",
+		},
+		[]string{"--zap-all /dev/sda1", "mkfs.ext4 -F -L"},
+	)
+	// /boot hides the mount point made for the EFI partition before it was mounted
+	want := []string{"/mnt/boot", "/mnt/boot/efi", "/mnt/data", "/mnt/home", "/mnt/snapshots", "/mnt/var/log", "/mnt/etc", "/mnt/boot/efi"}
+	if !slices.Equal(fake.dirs, want) {
+		t.Errorf("dirs = %q, want %q", fake.dirs, want)
+	}
+	if got := len(inst.checkpoint.Mounts); got != 7 {
+		t.Errorf("checkpoint has %d mounts, want 7", got)
+	}
+
+	fake.cmds = nil
+	inst.CleanupMounts()
+	assertCommands(t, fake.commands(),
+		[]string{"swapoff /dev/sda3", "umount -l /mnt/data", "umount -l /mnt/boot/efi", "umount -l /mnt/boot", "umount -l /mnt/var/log", "umount -l /mnt"},
+		nil,
+	)
+}
+
+// windowsDisk returns the partition table of a 100G /dev/sda holding a
+// Windows ESP and data partition, with 49.9 GiB free after them.
+func windowsDisk() *config.PartitionTable {
+	return &config.PartitionTable{
+		Label: "gpt", SectorSize: 512, FirstLBA: 2048, LastLBA: 209715166,
+		Partitions: []config.DiskPartition{
+			{Node: "/dev/sda1", Number: 1, Start: 2048, Sectors: 204800, Type: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"},
+			{Node: "/dev/sda2", Number: 2, Start: 206848, Sectors: 104857600, Type: "EBD0A0A2-B9E5-4433-87C0-68B6B72699C7"},
+		},
+	}
+}
+
+func TestInstallAlongside(t *testing.T) {
+	cfg := testConfig()
+	cfg.Device.Table = windowsDisk()
+	cfg.Alongside = true
+	inst, fake, _ := newTestInstaller(t, cfg)
+	ctx := context.Background()
+
+	for _, phase := range []func(*Installer, context.Context) error{
+		(*Installer).partition, (*Installer).mountFilesystems, (*Installer).installBase, (*Installer).installBootloader,
+	} {
+		if err := phase(inst, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertCommands(t, fake.commands(),
+		[]string{
+			"sgdisk -n 3:105064448:209715166 -t 3:8300 /dev/sda",
+			"mkfs.btrfs -f -L ArchRoot /dev/sda3",
+			"mount -o noatime,compress=zstd,subvol=@ /dev/sda3 /mnt",
+			"mount /dev/sda1 /mnt/efi",
+			"pacstrap /mnt base linux linux-firmware sudo vim btrfs-progs zram-generator grub efibootmgr networkmanager os-prober",
+			"grub-install --target=x86_64-efi --efi-directory=/efi",
+			"GRUB_DISABLE_OS_PROBER=false/' /etc/default/grub",
+			"grub-mkconfig",
+		},
+		[]string{"--zap-all", "mkfs.fat", "/dev/sda2"},
+	)
+
+	fake.cmds = nil
+	inst.CleanupMounts()
+	assertCommands(t, fake.commands(), []string{"umount -l /mnt/efi", "umount -l /mnt"}, []string{"/mnt/boot"})
+}
+
+func TestPartitionAlongsideNeedsESP(t *testing.T) {
+	cfg := testConfig()
+	cfg.Device.Table = windowsDisk()
+	cfg.Device.Table.Partitions = cfg.Device.Table.Partitions[1:]
+	cfg.Alongside = true
+	inst, fake, _ := newTestInstaller(t, cfg)
+
+	err := inst.partition(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "no EFI system partition") {
+		t.Errorf("partition() = %v, want missing ESP error", err)
+	}
+	if len(fake.cmds) > 0 {
+		t.Errorf("ran %v on a disk without an ESP", fake.commands())
+	}
+}
+
+func TestSetupLUKSPassphraseOnStdin(t *testing.T) {
+	cfg := testConfig()
+	cfg.Encrypt = true
+	cfg.LUKSPassphrase = "correct horse"
+	inst, fake, _ := newTestInstaller(t, cfg)
+
+	if err := inst.setupLUKS(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range fake.cmds {
+		if c.Name != "cryptsetup" {
+			continue
+		}
+		if c.Stdin != "correct horse\n" {
+			t.Errorf("%s: stdin = %q, want passphrase", c, c.Stdin)
+		}
+		if strings.Contains(c.String(), "correct horse") {
+			t.Errorf("passphrase leaked into command line: %s", c)
+		}
+	}
+}
+
+func TestShellMetacharactersStayArguments(t *testing.T) {
+	hostile := []string{`it's "quoted"`, "two words", "$(reboot)", "`id`; rm -rf /"}
+	cfg := testConfig()
+	cfg.Encrypt = true
+	secrets := []string{`r00t'pw"`, "pass $(word)", "luks `phrase`"}
+	cfg.RootPassword, cfg.UserPassword, cfg.LUKSPassphrase = secrets[0], secrets[1], secrets[2]
+	cfg.Packages = hostile
+	cfg.AURPackages = hostile
+	cfg.BundleFS = fstest.MapFS{"dots/rc": {Data: []byte("x\n")}}
+	cfg.Dotfiles = []config.Dotfile{{Src: "dots/rc", Dest: "~/" + hostile[1]}}
+	inst, fake, progress := newTestInstaller(t, cfg)
+	inst.Run(context.Background())
+
+	if last := drain(progress); last[len(last)-1].Err != nil {
+		t.Fatalf("install failed: %v", last[len(last)-1].Err)
+	}
+	args := map[string]bool{}
+	for _, c := range fake.cmds {
+		all := append([]string{c.Name}, c.Args...)
+		for i, a := range all {
+			args[a] = true
+			if i > 0 && all[i-1] == "-c" {
+				for _, h := range hostile {
+					if strings.Contains(a, h) {
+						t.Errorf("%q interpolated into shell script: %s", h, c)
+					}
+				}
+			}
+			for _, s := range secrets {
+				if strings.Contains(a, s) {
+					t.Errorf("secret passed as an argument: %s", c)
+				}
+			}
+		}
+	}
+	for _, h := range hostile {
+		if !args[h] {
+			t.Errorf("%q never passed as a single argument", h)
+		}
+	}
+	if !args["/home/alice/"+hostile[1]] {
+		t.Errorf("dotfile dest not passed as a single argument")
+	}
+}
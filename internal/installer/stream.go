@@ -0,0 +1,49 @@
+package installer
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+// lineWriter is an io.Writer that splits what is written to it into lines and
+// hands each non-blank line to fn as soon as it is complete. Carriage returns
+// end a line too, so progress output that redraws in place still shows up.
+type lineWriter struct {
+	mu  sync.Mutex
+	fn  func(line string)
+	buf []byte
+}
+
+func newLineWriter(fn func(line string)) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits any trailing output that did not end in a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.emit(w.buf)
+	w.buf = nil
+}
+
+func (w *lineWriter) emit(line []byte) {
+	if s := strings.TrimRight(string(line), " \t"); strings.TrimSpace(s) != "" {
+		w.fn(s)
+	}
+}
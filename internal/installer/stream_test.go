@@ -0,0 +1,48 @@
+package installer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := newLineWriter(func(line string) { lines = append(lines, line) })
+
+	w.Write([]byte(":: Synchronizing package databases...\n core"))
+	if len(lines) != 1 {
+		t.Fatalf("lines = %q, want only the complete line emitted", lines)
+	}
+	w.Write([]byte(" downloading...\r core is up to date\n\n   \n(1/2) installing"))
+	w.Flush()
+
+	want := []string{
+		":: Synchronizing package databases...",
+		" core downloading...",
+		" core is up to date",
+		"(1/2) installing",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+func TestRunStreamsOutput(t *testing.T) {
+	inst, fake, progress := newTestInstaller(t, testConfig())
+	fake.respond("pacstrap", "installing base...\ninstalling linux...\n", nil)
+
+	if err := inst.installBase(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var logs []string
+	for _, u := range drain(progress) {
+		if u.LogLine != "" {
+			logs = append(logs, u.LogLine)
+		}
+	}
+	want := []string{"Installing base system and packages (this may take a while)...", "installing base...", "installing linux..."}
+	if !reflect.DeepEqual(logs, want) {
+		t.Errorf("log lines = %q, want %q", logs, want)
+	}
+}
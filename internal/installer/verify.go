@@ -0,0 +1,218 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// check is the outcome of one post-install verification.
+type check struct {
+	name string
+	err  error
+}
+
+// verify inspects the installed system for the mistakes that would otherwise
+// only show up at first boot, and fails if any check does not pass.
+func (inst *Installer) verify(ctx context.Context) error {
+	var checks []check
+	add := func(name string, err error) {
+		checks = append(checks, check{name, err})
+	}
+
+	fstab, readErr := inst.readTarget(ctx, "/etc/fstab")
+	for _, m := range inst.checkpoint.Mounts {
+		err := readErr
+		if err == nil {
+			err = fstabHas(fstab, m)
+		}
+		add("fstab mounts "+m.Source+" at "+targetPath(m.Target), err)
+	}
+
+	grub, readErr := inst.readTarget(ctx, "/boot/grub/grub.cfg")
+	err := readErr
+	if err == nil {
+		err = grubHasKernel(grub)
+	}
+	add("grub.cfg boots linux", err)
+
+	if inst.cfg.Encrypt {
+		err := readErr
+		if err == nil {
+			err = inst.grubHasCryptdevice(ctx, grub)
+		}
+		add("grub.cfg unlocks cryptroot", err)
+
+		conf, err := inst.readTarget(ctx, "/etc/mkinitcpio.conf")
+		if err == nil {
+			err = hasEncryptHook(conf)
+		}
+		add("mkinitcpio.conf has the encrypt hook", err)
+	}
+
+	for _, unit := range inst.enabledUnits() {
+		add(unit+" is enabled", inst.unitEnabled(ctx, unit))
+	}
+
+	add("user "+inst.cfg.Username+" exists with shell "+inst.loginShell(), inst.userHasShell(ctx))
+
+	failed := 0
+	for _, c := range checks {
+		if c.err != nil {
+			failed++
+			inst.log("FAIL  " + c.name + ": " + c.err.Error())
+		} else {
+			inst.log("PASS  " + c.name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("verification failed: %d of %d checks did not pass", failed, len(checks))
+	}
+	inst.log(fmt.Sprintf("All %d verification checks passed", len(checks)))
+	return nil
+}
+
+// readTarget returns the contents of a file in the installed system. The
+// output is not streamed to the log.
+func (inst *Installer) readTarget(ctx context.Context, name string) (string, error) {
+	inst.logToFile("READ  %s%s", TargetRoot, name)
+	out, err := inst.target.Run(ctx, Command{Name: "cat", Args: []string{name}})
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", name, err)
+	}
+	return string(out), nil
+}
+
+// targetPath returns where a mount under TargetRoot appears in the installed system.
+func targetPath(p string) string {
+	if p == TargetRoot {
+		return "/"
+	}
+	return strings.TrimPrefix(p, TargetRoot)
+}
+
+// fstabHas checks that fstab mounts m at its target, with the same btrfs
+// subvolume when m has one.
+func fstabHas(fstab string, m MountPoint) error {
+	want := targetPath(m.Target)
+	var subvol string
+	for _, opt := range strings.Split(m.Options, ",") {
+		if v, ok := strings.CutPrefix(opt, "subvol="); ok {
+			subvol = "/" + strings.TrimPrefix(v, "/")
+		}
+	}
+	for _, line := range strings.Split(fstab, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 4 || strings.HasPrefix(f[0], "#") || f[1] != want {
+			continue
+		}
+		if subvol == "" {
+			return nil
+		}
+		for _, opt := range strings.Split(f[3], ",") {
+			if v, ok := strings.CutPrefix(opt, "subvol="); ok {
+				if "/"+strings.TrimPrefix(v, "/") == subvol {
+					return nil
+				}
+				return fmt.Errorf("%s is subvolume %s, want %s", want, v, subvol)
+			}
+		}
+		return fmt.Errorf("%s has no subvol option, want %s", want, subvol)
+	}
+	return fmt.Errorf("no entry for %s", want)
+}
+
+func grubHasKernel(grub string) error {
+	for _, file := range []string{"/vmlinuz-linux", "/initramfs-linux.img"} {
+		if !strings.Contains(grub, file) {
+			return fmt.Errorf("no menu entry loads %s", file)
+		}
+	}
+	return nil
+}
+
+// grubHasCryptdevice checks that the kernel command line unlocks the LUKS
+// partition by its current UUID.
+func (inst *Installer) grubHasCryptdevice(ctx context.Context, grub string) error {
+	out, err := inst.exec.Run(ctx, Command{Name: "blkid", Args: []string{"-s", "UUID", "-o", "value", inst.cfg.RootPartition()}})
+	if err != nil {
+		return fmt.Errorf("blkid: %w", err)
+	}
+	want := "cryptdevice=UUID=" + strings.TrimSpace(string(out)) + ":cryptroot"
+	if !strings.Contains(grub, want) {
+		return fmt.Errorf("kernel command line lacks %s", want)
+	}
+	return nil
+}
+
+// hasEncryptHook checks that the active HOOKS line runs encrypt before
+// filesystems are mounted.
+func hasEncryptHook(conf string) error {
+	for _, line := range strings.Split(conf, "\n") {
+		hooks, ok := strings.CutPrefix(strings.TrimSpace(line), "HOOKS=(")
+		if !ok {
+			continue
+		}
+		f := strings.Fields(strings.TrimSuffix(hooks, ")"))
+		enc, fs := slices.Index(f, "encrypt"), slices.Index(f, "filesystems")
+		if enc < 0 {
+			return fmt.Errorf("HOOKS has no encrypt hook")
+		}
+		if fs >= 0 && enc > fs {
+			return fmt.Errorf("encrypt hook runs after filesystems")
+		}
+		return nil
+	}
+	return fmt.Errorf("no HOOKS line")
+}
+
+// enabledUnits returns the units the configuration should have enabled.
+func (inst *Installer) enabledUnits() []string {
+	units := []string{"NetworkManager"}
+	if inst.cfg.SSD() {
+		units = append(units, "fstrim.timer")
+	}
+	if inst.cfg.SSHD {
+		units = append(units, "sshd")
+	}
+	if inst.cfg.Docker {
+		units = append(units, "docker")
+	}
+	if dm := inst.cfg.Desktop.DisplayManager(); dm != "" {
+		units = append(units, dm)
+	}
+	return units
+}
+
+func (inst *Installer) unitEnabled(ctx context.Context, unit string) error {
+	out, err := inst.target.Run(ctx, Command{Name: "systemctl", Args: []string{"is-enabled", unit}})
+	if state := strings.TrimSpace(string(out)); err != nil || state != "enabled" {
+		if state == "" {
+			state = "unknown"
+		}
+		return fmt.Errorf("state is %s", state)
+	}
+	return nil
+}
+
+// loginShell returns the shell the user should have been given.
+func (inst *Installer) loginShell() string {
+	if inst.cfg.Shell == "zsh" {
+		return "zsh"
+	}
+	return "bash"
+}
+
+func (inst *Installer) userHasShell(ctx context.Context) error {
+	out, err := inst.target.Run(ctx, Command{Name: "getent", Args: []string{"passwd", inst.cfg.Username}})
+	if err != nil {
+		return fmt.Errorf("no such user")
+	}
+	f := strings.Split(strings.TrimSpace(string(out)), ":")
+	if shell := f[len(f)-1]; path.Base(shell) != inst.loginShell() {
+		return fmt.Errorf("shell is %s", shell)
+	}
+	return nil
+}
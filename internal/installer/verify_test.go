@@ -0,0 +1,98 @@
+package installer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tallenh/archy/internal/config"
+)
+
+func TestVerify(t *testing.T) {
+	defaultMounts := []MountPoint{
+		{"/dev/sda2", "/mnt", "noatime,compress=zstd,subvol=@"},
+		{"/dev/sda2", "/mnt/home", "noatime,compress=zstd,subvol=@home"},
+		{"/dev/sda1", "/mnt/boot", ""},
+	}
+	tests := []struct {
+		name  string
+		setup func(cfg *config.InstallConfig, f *fakeExecutor)
+		fail  []string // substrings of the FAIL lines expected, in order
+	}{
+		{name: "healthy"},
+		{
+			name: "fstab subvolume mismatch",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("arch-chroot /mnt cat /etc/fstab", strings.Replace(targetFstab, "subvol=/@home", "subvol=/@", 1), nil)
+			},
+			fail: []string{"/home is subvolume /@, want /@home"},
+		},
+		{
+			name: "fstab missing",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("arch-chroot /mnt cat /etc/fstab", "cat: /etc/fstab: No such file or directory", errFake)
+			},
+			fail: []string{"read /etc/fstab", "read /etc/fstab", "read /etc/fstab"},
+		},
+		{
+			name: "grub edits did not apply",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Encrypt = true
+				f.respond("arch-chroot /mnt cat /boot/grub/grub.cfg", "linux /@/boot/vmlinuz-linux root=UUID=f00d\ninitrd /@/boot/initramfs-linux.img\n", nil)
+				f.respond("arch-chroot /mnt cat /etc/mkinitcpio.conf", "HOOKS=(base udev autodetect block filesystems fsck)\n", nil)
+			},
+			fail: []string{"lacks cryptdevice=UUID=c0ffee:cryptroot", "no encrypt hook"},
+		},
+		{
+			name: "no kernel in grub.cfg",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				f.respond("arch-chroot /mnt cat /boot/grub/grub.cfg", "menuentry 'UEFI Firmware Settings'\n", nil)
+			},
+			fail: []string{"vmlinuz-linux"},
+		},
+		{
+			name: "display manager not enabled",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Desktop = config.DesktopKDE
+				cfg.SSHD = true
+				f.respond("arch-chroot /mnt systemctl is-enabled sddm", "disabled\n", errFake)
+			},
+			fail: []string{"sddm is enabled: state is disabled"},
+		},
+		{
+			name: "wrong shell",
+			setup: func(cfg *config.InstallConfig, f *fakeExecutor) {
+				cfg.Shell = "zsh"
+			},
+			fail: []string{"shell is /bin/bash"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			inst, fake, progress := newTestInstaller(t, cfg)
+			inst.checkpoint.Mounts = defaultMounts
+			if tt.setup != nil {
+				tt.setup(cfg, fake)
+			}
+			err := inst.verify(context.Background())
+			if (err != nil) != (len(tt.fail) > 0) {
+				t.Fatalf("verify() = %v, want %d failure(s)", err, len(tt.fail))
+			}
+			var failed []string
+			for _, u := range drain(progress) {
+				if strings.HasPrefix(u.LogLine, "FAIL") {
+					failed = append(failed, u.LogLine)
+				}
+			}
+			if len(failed) != len(tt.fail) {
+				t.Fatalf("failures = %q, want %d", failed, len(tt.fail))
+			}
+			for i, line := range failed {
+				if !strings.Contains(line, tt.fail[i]) {
+					t.Errorf("failure %d = %q, want %q", i, line, tt.fail[i])
+				}
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package system
 
 import (
 	"encoding/json"
+	"fmt"
 	"os/exec"
+	"strings"
 
 	"github.com/tallenh/archy/internal/config"
 )
@@ -12,15 +14,34 @@ type lsblkOutput struct {
 }
 
 type lsblkDevice struct {
-	Name string `json:"name"`
-	Size string `json:"size"`
-	Type string `json:"type"`
-	Model string `json:"model"`
+	Name  string    `json:"name"`
+	Size  string    `json:"size"`
+	Type  string    `json:"type"`
+	Model string    `json:"model"`
+	Rota  lsblkBool `json:"rota"`
+	Tran  string    `json:"tran"`
 }
 
-// DetectDisks runs lsblk and returns a list of whole-disk block devices.
+// lsblkBool decodes lsblk's boolean columns, which util-linux before 2.33
+// prints as "0" and "1".
+type lsblkBool bool
+
+func (b *lsblkBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true", "1":
+		*b = true
+	case "false", "0", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid lsblk boolean %s", data)
+	}
+	return nil
+}
+
+// DetectDisks runs lsblk and returns a list of whole-disk block devices,
+// noting which are solid state or NVMe, with their partition tables.
 func DetectDisks() ([]config.BlockDevice, error) {
-	out, err := exec.Command("lsblk", "-J", "-d", "-o", "NAME,SIZE,TYPE,MODEL").Output()
+	out, err := exec.Command("lsblk", "-J", "-d", "-o", "NAME,SIZE,TYPE,MODEL,ROTA,TRAN").Output()
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +58,24 @@ func DetectDisks() ([]config.BlockDevice, error) {
 			Name:  d.Name,
 			Size:  d.Size,
 			Model: d.Model,
+			SSD:   !bool(d.Rota),
+			NVMe:  d.Tran == "nvme",
+			Table: readPartitionTable("/dev/" + d.Name),
 		})
 	}
 	return devices, nil
 }
+
+// readPartitionTable returns the partition table of dev, or nil when it has
+// none that sfdisk can read.
+func readPartitionTable(dev string) *config.PartitionTable {
+	out, err := exec.Command("sfdisk", "--json", dev).Output()
+	if err != nil {
+		return nil
+	}
+	t, err := config.ParsePartitionTable(out)
+	if err != nil {
+		return nil
+	}
+	return t
+}
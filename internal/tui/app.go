@@ -58,6 +58,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, m.keys.Quit):
+			if s, ok := m.steps[m.current].(Interrupter); ok && s.Interrupt() {
+				return m, nil
+			}
 			m.quitting = true
 			return m, tea.Quit
 		case key.Matches(msg, m.keys.Back):
@@ -101,7 +104,7 @@ func (m Model) View() string {
 
 func (m Model) helpText() string {
 	if m.current == StepInstall {
-		return "ctrl+c quit"
+		return "ctrl+c cancel"
 	}
 	if m.current == StepWelcome {
 		return "enter next • ctrl+c quit"
@@ -156,6 +159,17 @@ func (m Model) shouldSkip(step Step) bool {
 		return false
 	}
 
+	// The disk layout of a resumed install is fixed by its checkpoint
+	if m.config.Resume && (step == StepDevice || step == StepPartSize || step == StepEncrypt) {
+		return true
+	}
+
+	// A partition layout from archy.toml sizes the EFI partition itself, and
+	// an install alongside other systems reuses theirs
+	if step == StepPartSize && (len(m.config.Partitions) > 0 || m.config.Alongside) {
+		return true
+	}
+
 	// Password steps are skipped in both modes when env var provided the value
 	if step == StepUserPassword && m.config.UserPassword != "" {
 		return true
@@ -33,3 +33,10 @@ type StepModel interface {
 	// Title returns the step's heading.
 	Title() string
 }
+
+// Interrupter is implemented by steps that must clean up before the wizard
+// quits. Interrupt reports whether the step took over shutdown; when it
+// returns false the wizard quits immediately.
+type Interrupter interface {
+	Interrupt() bool
+}
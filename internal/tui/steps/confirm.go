@@ -1,27 +1,62 @@
 package steps
 
 import (
+	"errors"
+
 	tea "github.com/charmbracelet/bubbletea"
 
 	"github.com/tallenh/archy/internal/config"
+	"github.com/tallenh/archy/internal/installer"
 	"github.com/tallenh/archy/internal/tui"
 )
 
 type Confirm struct {
-	cfg *config.InstallConfig
+	cfg      *config.InstallConfig
+	failures []error // pre-flight check failures
+	checking bool    // pre-flight checks are running
 }
 
+// preflightMsg carries the result of the pre-flight checks.
+type preflightMsg []error
+
 func NewConfirm(cfg *config.InstallConfig) *Confirm {
 	return &Confirm{cfg: cfg}
 }
 
 func (c *Confirm) Title() string { return "Confirm Installation" }
 
-func (c *Confirm) Init() tea.Cmd { return nil }
+// Init starts the pre-flight checks in the background: resolving packages
+// can take a while when pacman has to refresh its databases.
+func (c *Confirm) Init() tea.Cmd {
+	return c.check()
+}
+
+func (c *Confirm) check() tea.Cmd {
+	c.checking = true
+	cfg := c.cfg
+	return func() tea.Msg { return preflightMsg(installer.Preflight(cfg)) }
+}
+
+// blocked reports whether pre-flight failures prevent the install. A dry run
+// touches nothing, so it only shows them.
+func (c *Confirm) blocked() bool {
+	return len(c.failures) > 0 && !c.cfg.DryRun
+}
 
 func (c *Confirm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(preflightMsg); ok {
+		c.failures = msg
+		c.checking = false
+		return c, nil
+	}
 	if msg, ok := msg.(tea.KeyMsg); ok {
 		if msg.String() == "enter" {
+			if c.checking {
+				return c, nil
+			}
+			if c.blocked() {
+				return c, c.check()
+			}
 			return c, func() tea.Msg { return tui.StartInstallMsg{} }
 		}
 	}
@@ -30,7 +65,46 @@ func (c *Confirm) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (c *Confirm) View() string {
 	s := c.cfg.Summary() + "\n"
-	s += tui.ErrorStyle.Render("WARNING: This will ERASE ALL DATA on " + c.cfg.Device.Path()) + "\n\n"
+	if c.cfg.Resume {
+		s += tui.ErrorStyle.Render("RESUME: continuing the previous install on "+c.cfg.Device.Path()+"; completed phases are skipped") + "\n\n"
+	} else if c.cfg.DryRun {
+		s += tui.MutedStyle.Render("DRY RUN: commands are recorded, nothing is written to "+c.cfg.Device.Path()) + "\n\n"
+	} else if c.cfg.Alongside {
+		s += tui.ErrorStyle.Render("WARNING: This will partition the free space on "+c.cfg.Device.Path()+" and install GRUB to its EFI partition; back up the other systems first") + "\n\n"
+	} else {
+		s += tui.ErrorStyle.Render("WARNING: This will ERASE ALL DATA on "+c.cfg.Device.Path()) + "\n\n"
+	}
+	if c.checking {
+		s += tui.MutedStyle.Render("Running pre-flight checks...")
+		return s
+	}
+	var checks, packages []string
+	for _, err := range c.failures {
+		var unknown *installer.UnknownPackageError
+		if errors.As(err, &unknown) {
+			packages = append(packages, unknown.Error())
+		} else {
+			checks = append(checks, err.Error())
+		}
+	}
+	if len(checks) > 0 {
+		s += tui.ErrorStyle.Render("Pre-flight checks failed:") + "\n"
+		for _, line := range checks {
+			s += tui.ErrorStyle.Render("  ✗ "+line) + "\n"
+		}
+		s += "\n"
+	}
+	if len(packages) > 0 {
+		s += tui.ErrorStyle.Render("Unknown packages:") + "\n"
+		for _, line := range packages {
+			s += tui.ErrorStyle.Render("  ✗ "+line) + "\n"
+		}
+		s += "\n"
+	}
+	if c.blocked() {
+		s += tui.MutedStyle.Render("Fix the problems above and press Enter to re-check, Esc to go back.")
+		return s
+	}
 	s += tui.MutedStyle.Render("Press Enter to begin installation, Esc to go back.")
 	return s
 }
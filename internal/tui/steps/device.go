@@ -1,6 +1,11 @@
 package steps
 
 import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 
@@ -12,13 +17,16 @@ type deviceItem struct {
 	device config.BlockDevice
 }
 
-func (d deviceItem) Title() string       { return d.device.Path() }
-func (d deviceItem) Description() string { return d.device.Size + "  " + d.device.Model }
+func (d deviceItem) Title() string { return d.device.Path() }
+func (d deviceItem) Description() string {
+	return d.device.Size + "  " + d.device.Kind() + "  " + d.device.Model
+}
 func (d deviceItem) FilterValue() string { return d.device.Name }
 
 type Device struct {
 	cfg  *config.InstallConfig
 	list list.Model
+	err  string
 }
 
 func NewDevice(cfg *config.InstallConfig, disks []config.BlockDevice) *Device {
@@ -48,6 +56,13 @@ func (d *Device) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if msg, ok := msg.(tea.KeyMsg); ok {
 		if msg.String() == "enter" {
 			if item, ok := d.list.SelectedItem().(deviceItem); ok {
+				if d.cfg.Alongside {
+					if err := item.device.Table.CheckAlongside(); err != nil {
+						d.err = err.Error()
+						return d, nil
+					}
+				}
+				d.err = ""
 				d.cfg.Device = item.device
 				return d, func() tea.Msg { return tui.SubmitMsg{} }
 			}
@@ -59,5 +74,52 @@ func (d *Device) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (d *Device) View() string {
-	return d.list.View()
+	if !d.cfg.Alongside {
+		return d.list.View()
+	}
+	s := d.list.View()
+	if item, ok := d.list.SelectedItem().(deviceItem); ok {
+		s += "\n" + tableView(item.device.Table)
+	}
+	if d.err != "" {
+		s += "\n" + tui.ErrorStyle.Render(d.err)
+	}
+	return s
+}
+
+// tableView lists the partitions and unallocated regions of a disk in disk
+// order, marking the EFI partition that is kept and the free space root goes
+// into.
+func tableView(t *config.PartitionTable) string {
+	if t == nil {
+		return tui.MutedStyle.Render("No partition table")
+	}
+	type row struct {
+		start int64
+		line  string
+	}
+	var rows []row
+	esp, _ := t.ESP()
+	for _, p := range t.Partitions {
+		line := fmt.Sprintf("  %-16s %10s  %s", p.Node, config.FormatBytes(t.Bytes(p.Sectors)), p.Name)
+		if p.Node == esp.Node {
+			line += tui.SuccessStyle.Render("  (EFI, kept)")
+		}
+		rows = append(rows, row{p.Start, line})
+	}
+	largest, _ := t.LargestFree()
+	for _, r := range t.Free() {
+		line := tui.MutedStyle.Render(fmt.Sprintf("  %-16s %10s", "free", config.FormatBytes(t.Bytes(r.Sectors))))
+		if r == largest {
+			line += tui.SuccessStyle.Render("  (root)")
+		}
+		rows = append(rows, row{r.Start, line})
+	}
+	slices.SortFunc(rows, func(a, b row) int { return cmp.Compare(a.start, b.start) })
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s partition table:\n", strings.ToUpper(t.Label))
+	for _, r := range rows {
+		b.WriteString(r.line + "\n")
+	}
+	return b.String()
 }
@@ -1,6 +1,8 @@
 package steps
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -18,6 +20,7 @@ type PhaseUpdateMsg installer.PhaseUpdate
 
 type Install struct {
 	cfg      *config.InstallConfig
+	exec     installer.Executor
 	spinner  spinner.Model
 	progress progress.Model
 	logs     []string
@@ -26,9 +29,18 @@ type Install struct {
 	done     bool
 	err      error
 	sub      <-chan installer.PhaseUpdate
+	cancel   context.CancelFunc
+	aborting bool                      // cancel requested, waiting for the installer to clean up
+	prefetch *installer.PrefetchStatus // background package download, once started
+
+	// Recovery menu, shown while the installer waits for a decision
+	decisions   chan<- installer.Decision
+	actions     []recoveryAction
+	cursor      int
+	recoveryErr error // from the last shell or log viewer
 }
 
-func NewInstall(cfg *config.InstallConfig) *Install {
+func NewInstall(cfg *config.InstallConfig, exec installer.Executor) *Install {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 
@@ -37,6 +49,7 @@ func NewInstall(cfg *config.InstallConfig) *Install {
 
 	return &Install{
 		cfg:      cfg,
+		exec:     exec,
 		spinner:  s,
 		progress: p,
 	}
@@ -48,9 +61,16 @@ func (i *Install) Init() tea.Cmd {
 	ch := make(chan installer.PhaseUpdate, 20)
 	i.sub = ch
 
-	inst := installer.New(i.cfg, ch)
+	ctx, cancel := context.WithCancel(context.Background())
+	i.cancel = cancel
+
+	decisions := make(chan installer.Decision)
+	i.decisions = decisions
+
+	inst := installer.New(i.cfg, i.exec, ch)
+	inst.AwaitDecisions(decisions)
 	go func() {
-		inst.Run()
+		inst.Run(ctx)
 		close(ch)
 	}()
 
@@ -70,10 +90,24 @@ func (i *Install) waitForUpdate() tea.Cmd {
 	}
 }
 
+// Interrupt cancels a running install. The wizard keeps running until the
+// installer has unmounted the target and closed LUKS; a second interrupt
+// quits immediately.
+func (i *Install) Interrupt() bool {
+	if i.done || i.aborting || i.cancel == nil {
+		return false
+	}
+	i.aborting = true
+	i.actions = nil
+	i.phase = "Cancelling installation..."
+	i.cancel()
+	return true
+}
+
 func (i *Install) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case PhaseUpdateMsg:
-		if msg.Description != "" {
+		if msg.Description != "" && !i.aborting {
 			i.phase = msg.Description
 		}
 		if msg.Percent > i.percent {
@@ -82,9 +116,25 @@ func (i *Install) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.LogLine != "" {
 			i.logs = append(i.logs, msg.LogLine)
 		}
+		if msg.Prefetch != nil {
+			i.prefetch = msg.Prefetch
+		}
+		if msg.Awaiting {
+			// Keep listening: cancelling makes the installer clean up
+			// without a decision
+			if !i.aborting {
+				i.err = msg.Err
+				i.actions = recoveryActions(msg.Skippable)
+				i.cursor = 0
+			}
+			return i, i.waitForUpdate()
+		}
 		if msg.Err != nil {
 			i.err = msg.Err
 			i.done = true
+			if i.aborting {
+				return i, tea.Quit
+			}
 			return i, nil
 		}
 		if msg.Done {
@@ -92,6 +142,13 @@ func (i *Install) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return i, nil
 		}
 		return i, tea.Batch(i.spinner.Tick, i.waitForUpdate())
+	case tea.KeyMsg:
+		if i.actions != nil {
+			return i.updateRecovery(msg)
+		}
+	case recoveryDoneMsg:
+		i.recoveryErr = msg.err
+		return i, nil
 	case spinner.TickMsg:
 		var cmd tea.Cmd
 		i.spinner, cmd = i.spinner.Update(msg)
@@ -103,12 +160,24 @@ func (i *Install) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (i *Install) View() string {
 	var b strings.Builder
 
-	if !i.done {
+	if i.actions != nil {
+		b.WriteString(i.viewRecovery())
+	} else if !i.done {
 		fmt.Fprintf(&b, "%s %s\n\n", i.spinner.View(), i.phase)
 		b.WriteString(i.progress.ViewAs(i.percent) + "\n\n")
+		if s := i.viewPrefetch(); s != "" {
+			b.WriteString(s + "\n\n")
+		}
+	} else if errors.Is(i.err, context.Canceled) {
+		fmt.Fprintf(&b, "%s\n\n", tui.ErrorStyle.Render("Installation cancelled. Mounts were cleaned up."))
+		b.WriteString(i.progress.ViewAs(i.percent) + "\n\n")
 	} else if i.err != nil {
 		fmt.Fprintf(&b, "%s\n\n", tui.ErrorStyle.Render("Installation failed: "+i.err.Error()))
 		b.WriteString(i.progress.ViewAs(i.percent) + "\n\n")
+	} else if i.cfg.DryRun {
+		b.WriteString(tui.SuccessStyle.Render("Dry run complete!") + "\n\n")
+		b.WriteString(i.progress.ViewAs(1.0) + "\n\n")
+		b.WriteString("No changes were made. The install plan is written when archy exits.\n")
 	} else {
 		b.WriteString(tui.SuccessStyle.Render("Installation complete!") + "\n\n")
 		b.WriteString(i.progress.ViewAs(1.0) + "\n\n")
@@ -126,3 +195,19 @@ func (i *Install) View() string {
 
 	return b.String()
 }
+
+// viewPrefetch renders the background package download, if there is one.
+func (i *Install) viewPrefetch() string {
+	p := i.prefetch
+	switch {
+	case p == nil:
+		return ""
+	case p.Err != nil:
+		return tui.ErrorStyle.Render("Background download failed, packages will be fetched during the base install")
+	case p.Done:
+		return tui.SuccessStyle.Render("Packages downloaded")
+	case p.Total == 0:
+		return tui.MutedStyle.Render("Downloading packages in the background...")
+	}
+	return tui.MutedStyle.Render(fmt.Sprintf("Downloading packages in the background: %d/%d", p.Downloaded, p.Total))
+}
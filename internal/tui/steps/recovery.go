@@ -0,0 +1,109 @@
+package steps
+
+import (
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/tallenh/archy/internal/installer"
+	"github.com/tallenh/archy/internal/tui"
+)
+
+// recoveryAction is an entry in the menu shown when a phase fails.
+type recoveryAction struct {
+	key   string
+	label string
+	// decision is sent to the installer; actions without one run a program
+	// and come back to the menu.
+	decision *installer.Decision
+	program  func() *exec.Cmd
+}
+
+// recoveryDoneMsg is sent when a program started from the recovery menu exits.
+type recoveryDoneMsg struct{ err error }
+
+func decide(d installer.Decision) *installer.Decision { return &d }
+
+// recoveryActions returns the menu for a failed phase. Skipping is only
+// offered when the installer allows it.
+func recoveryActions(skippable bool) []recoveryAction {
+	actions := []recoveryAction{
+		{key: "r", label: "Retry the failed phase", decision: decide(installer.DecisionRetry)},
+	}
+	if skippable {
+		actions = append(actions, recoveryAction{key: "s", label: "Skip it and continue", decision: decide(installer.DecisionSkip)})
+	}
+	return append(actions,
+		recoveryAction{key: "l", label: "Open a shell in the live system", program: func() *exec.Cmd {
+			return exec.Command("bash")
+		}},
+		recoveryAction{key: "c", label: "Open a shell in the new system (arch-chroot " + installer.TargetRoot + ")", program: func() *exec.Cmd {
+			return exec.Command("arch-chroot", installer.TargetRoot, "bash")
+		}},
+		recoveryAction{key: "v", label: "View the full log", program: func() *exec.Cmd {
+			return exec.Command("less", "+G", installer.LogPath)
+		}},
+		recoveryAction{key: "q", label: "Clean up mounts and quit", decision: decide(installer.DecisionAbort)},
+	)
+}
+
+// updateRecovery handles keys while the installer waits for a decision.
+func (i *Install) updateRecovery(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	choose := -1
+	switch msg.String() {
+	case "up", "k":
+		if i.cursor > 0 {
+			i.cursor--
+		}
+	case "down", "j":
+		if i.cursor < len(i.actions)-1 {
+			i.cursor++
+		}
+	case "enter":
+		choose = i.cursor
+	default:
+		for n, a := range i.actions {
+			if msg.String() == a.key {
+				choose = n
+			}
+		}
+	}
+	if choose < 0 {
+		return i, nil
+	}
+
+	a := i.actions[choose]
+	if a.program != nil {
+		return i, tea.ExecProcess(a.program(), func(err error) tea.Msg { return recoveryDoneMsg{err} })
+	}
+	i.actions = nil
+	i.recoveryErr = nil
+	i.err = nil
+	i.decisions <- *a.decision
+	if *a.decision == installer.DecisionAbort {
+		// Quit once the installer reports that it has cleaned up
+		i.aborting = true
+		i.phase = "Cleaning up mounts..."
+	}
+	// The update that paused the installer already started the next wait
+	return i, i.spinner.Tick
+}
+
+func (i *Install) viewRecovery() string {
+	var b strings.Builder
+	b.WriteString(tui.ErrorStyle.Render(i.phase+" failed: "+i.err.Error()) + "\n\n")
+	for n, a := range i.actions {
+		line := "[" + a.key + "] " + a.label
+		if n == i.cursor {
+			b.WriteString(tui.ActiveStyle.Render("> "+line) + "\n")
+		} else {
+			b.WriteString("  " + line + "\n")
+		}
+	}
+	if i.recoveryErr != nil {
+		b.WriteString("\n" + tui.ErrorStyle.Render(i.recoveryErr.Error()) + "\n")
+	}
+	b.WriteString("\n" + tui.MutedStyle.Render("↑/↓ to move, Enter or a letter to choose") + "\n\n")
+	return b.String()
+}